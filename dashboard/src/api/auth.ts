@@ -2,9 +2,69 @@ import api from './index'
 
 export interface LoginResponse {
   token: string
+  refreshToken: string
+  expiresIn: number
   username: string
+  // 两步验证：密码正确后返回 mfaToken，需再提交验证码
+  mfaRequired?: boolean
+  setupRequired?: boolean
+  mfaToken?: string
+  // 首次绑定两步验证成功后返回（只显示一次）
+  recoveryCodes?: string[]
 }
 
 export function login(username: string, password: string) {
   return api.post<LoginResponse>('/auth/login', { username, password })
 }
+
+/**
+ * 两步验证：提交验证码或恢复码完成登录
+ */
+export function loginTOTP(mfaToken: string, code: string) {
+  return api.post<LoginResponse>('/auth/login/totp', { mfaToken, code })
+}
+
+export interface TOTPSetupResponse {
+  secret: string
+  otpauthUri: string
+}
+
+/**
+ * 管理员要求启用两步验证的用户首次登录时获取密钥
+ */
+export function setupLoginTOTP(mfaToken: string) {
+  return api.post<TOTPSetupResponse>('/auth/totp/setup', { mfaToken })
+}
+
+/**
+ * 确认绑定两步验证并完成登录
+ */
+export function activateLoginTOTP(mfaToken: string, code: string) {
+  return api.post<LoginResponse>('/auth/totp/activate', { mfaToken, code })
+}
+
+export interface AuthConfig {
+  passwordLogin: boolean
+  oidc: boolean
+}
+
+/**
+ * 获取可用的登录方式
+ */
+export function getAuthConfig() {
+  return api.get<AuthConfig>('/auth/config')
+}
+
+/**
+ * 单点登录：用回调返回的一次性登录码换取 Token
+ */
+export function exchangeOIDCCode(code: string) {
+  return api.post<LoginResponse>('/auth/oidc/token', { code })
+}
+
+/**
+ * 退出登录：服务端吊销当前会话
+ */
+export function logout() {
+  return api.post('/auth/logout')
+}
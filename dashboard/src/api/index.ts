@@ -1,4 +1,4 @@
-import axios from 'axios'
+import axios, { type AxiosRequestConfig } from 'axios'
 import { useAuthStore } from '@/stores/auth'
 import { useAppStore } from '@/stores/app'
 
@@ -22,10 +22,48 @@ api.interceptors.request.use((config) => {
   return config
 })
 
+/**
+ * 进行中的刷新请求，多个请求同时 401 时共用一次刷新（Refresh Token 只能使用一次）
+ */
+let refreshing: Promise<boolean> | null = null
+
+/**
+ * 使用 Refresh Token 换取新的 Access Token
+ */
+function refreshAccessToken(): Promise<boolean> {
+  const authStore = useAuthStore()
+  if (!authStore.refreshToken) {
+    return Promise.resolve(false)
+  }
+  if (!refreshing) {
+    refreshing = axios
+      .post('/auth/refresh', { refreshToken: authStore.refreshToken })
+      .then((res) => {
+        authStore.setAuth(res.data.token, res.data.refreshToken, res.data.username)
+        return true
+      })
+      .catch(() => false)
+      .finally(() => {
+        refreshing = null
+      })
+  }
+  return refreshing
+}
+
 api.interceptors.response.use(
   (res) => res,
-  (err) => {
-    if (err.response?.status === 401) {
+  async (err) => {
+    const config = err.config as (AxiosRequestConfig & { _retried?: boolean }) | undefined
+    const isAuthRequest = config?.url?.startsWith('/auth/')
+    if (err.response?.status === 401 && config && !config._retried && !isAuthRequest) {
+      // Access Token 过期：刷新后重试一次
+      config._retried = true
+      if (await refreshAccessToken()) {
+        return api(config)
+      }
+    }
+    const isLoginRequest = config?.url?.startsWith('/auth/login') || config?.url?.startsWith('/auth/totp/')
+    if (err.response?.status === 401 && !isLoginRequest) {
       // 使用 store 的 logout 方法，会自动清除持久化状态
       const authStore = useAuthStore()
       authStore.logout()
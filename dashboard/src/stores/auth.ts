@@ -3,26 +3,29 @@ import { ref, computed } from 'vue'
 
 export const useAuthStore = defineStore('auth', () => {
   const token = ref('')
+  const refreshToken = ref('')
   const username = ref('')
   const isLoggedIn = computed(() => !!token.value)
 
-  function setAuth(newToken: string, newUsername: string) {
+  function setAuth(newToken: string, newRefreshToken: string, newUsername: string) {
     token.value = newToken
+    refreshToken.value = newRefreshToken
     username.value = newUsername
     // Pinia 持久化插件会自动保存，不需要手动操作 localStorage
   }
 
   function logout() {
     token.value = ''
+    refreshToken.value = ''
     username.value = ''
     // Pinia 持久化插件会自动清除，不需要手动操作 localStorage
   }
 
-  return { token, username, isLoggedIn, setAuth, logout }
+  return { token, refreshToken, username, isLoggedIn, setAuth, logout }
 }, {
   persist: {
     key: 'auth-store',
     storage: localStorage,
-    pick: ['token', 'username'],
+    pick: ['token', 'refreshToken', 'username'],
   },
 })
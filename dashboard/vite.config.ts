@@ -34,9 +34,10 @@ export default defineConfig({
     vue(),
   ],
   server: {
+    // 保留 Host，后端据此将开发服务器的请求视为同源（CORS 只放行同源和 dashboardOrigins）
     proxy: {
-      '/api': { target: 'http://localhost:3001', changeOrigin: true },
-      '/auth': { target: 'http://localhost:3001', changeOrigin: true },
+      '/api': { target: 'http://localhost:3001' },
+      '/auth': { target: 'http://localhost:3001' },
     },
   },
   optimizeDeps: {
@@ -2,169 +2,433 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"regexp"
 	"sync"
 
+	"github.com/hanxi/tracely/internal/scrub"
 	"github.com/spf13/viper"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // Config 服务器配置
 type Config struct {
-	Port         string
-	DBPath       string
-	RateLimit    int
-	NonceTTL     int
-	TimestampTTL int
-	JWT          JWT
-	Apps         []App
-	Users        []User
-	Events       []EventConfig // 自定义事件配置（白名单）
-}
-
-// App 应用配置（SDK 上报用）
+	Port                 string
+	DBPath               string
+	RateLimit            int // 兼容旧配置：每个 IP 每分钟的上报次数，rateLimiter.ipPerMinute 未配置时使用
+	NonceTTL             int
+	TimestampTTL         int
+	RollupInterval       int // 汇总任务执行间隔（秒）
+	JWT                  JWT
+	OIDC                 OIDC
+	LoginGuard           LoginGuard
+	Metrics              Metrics
+	Ingest               Ingest
+	RateLimiter          RateLimiter
+	SpikeProtection      SpikeProtection
+	Scrub                Scrub
+	EventDiscovery       EventDiscovery
+	DisablePasswordLogin bool     // 禁用用户名密码登录（仅允许 OIDC 单点登录）
+	DashboardOrigins     []string // 允许跨域调用 Dashboard 接口的来源，同源访问无需配置
+	TrustedProxies       []string // 受信任的反向代理（IP 或 CIDR），只有来自这些地址的 X-Forwarded-For 才用于识别客户端 IP，为空表示不信任
+	Apps                 []App
+	Users                []User
+	Events               []EventConfig // 自定义事件配置（白名单）
+}
+
+// App 应用配置（SDK 上报用，首次启动时导入数据库）
 type App struct {
-	AppID     string `yaml:"appId"`
-	AppName   string `yaml:"appName"`
-	AppSecret string `yaml:"appSecret"`
+	AppID          string   `yaml:"appId"`
+	AppName        string   `yaml:"appName"`
+	AppSecret      string   `yaml:"appSecret"`
+	AllowedOrigins []string `yaml:"allowedOrigins"` // 浏览器 SDK 允许的来源，为空表示不限制
+	Quota          AppQuota `yaml:"quota"`
+	Scrub          AppScrub `yaml:"scrub"` // 覆盖全局脱敏规则
+}
+
+// AppScrub 应用的脱敏规则覆盖：enabled、detectors 不配置时沿用全局，patterns、denyKeys 追加到全局规则
+type AppScrub struct {
+	Enabled   *bool     `yaml:"enabled"`
+	Detectors *[]string `yaml:"detectors"`
+	Patterns  []string  `yaml:"patterns"`
+	DenyKeys  []string  `yaml:"denyKeys"`
+}
+
+// AppQuota 应用上报配额（按 UTC 自然日/自然月），0 表示不限制
+type AppQuota struct {
+	DailyEvents   int64 `yaml:"dailyEvents"`
+	MonthlyEvents int64 `yaml:"monthlyEvents"`
+	DailyErrors   int64 `yaml:"dailyErrors"`
+	MonthlyErrors int64 `yaml:"monthlyErrors"`
 }
 
-// User 用户配置（Dashboard 登录用）
+// 用户角色
+const (
+	RoleAdmin  = "admin"  // 管理员：全部应用 + 管理接口
+	RoleMember = "member" // 成员：可查看和操作允许的应用
+	RoleViewer = "viewer" // 访客：只读
+)
+
+// User 用户配置（Dashboard 登录用，首次启动时导入数据库）
 type User struct {
-	Username     string `yaml:"username"`
-	PasswordHash string `yaml:"passwordHash"`
+	Username     string   `yaml:"username"`
+	PasswordHash string   `yaml:"passwordHash"`
+	Role         string   `yaml:"role"`       // admin / member / viewer，未配置时为 admin（兼容旧配置）
+	Apps         []string `yaml:"apps"`       // 允许访问的 AppID，为空表示全部应用（admin 始终为全部）
+	TOTPSecret   string   `yaml:"totpSecret"` // 两步验证密钥（./tracely -totp 生成，只在首次初始化用户时写入），为空表示未启用
 }
 
-// EventConfig 事件配置（白名单）
+// EventConfig 事件配置（白名单，首次启动时导入数据库）
 type EventConfig struct {
-	EventName     string `yaml:"eventName"`
-	Description   string `yaml:"description"`
-	RetentionDays int    `yaml:"retentionDays"` // 数据保留天数（0=永久保留）
+	EventName     string       `yaml:"eventName"` // 事件名称，match 为 glob / regex 时为匹配模式
+	Match         string       `yaml:"match"`     // 匹配方式：exact（默认）/ glob（如 checkout_*）/ regex
+	Description   string       `yaml:"description"`
+	RetentionDays int          `yaml:"retentionDays"` // 数据保留天数（0=永久保留）
+	Schema        *EventSchema `yaml:"schema"`        // metadata 结构约束，不配置表示不校验
+}
+
+// EventSchema 事件 metadata 结构约束
+type EventSchema struct {
+	Properties           map[string]string `yaml:"properties"`           // 属性名 -> 类型：string / number / integer / boolean / object / array / any
+	Required             []string          `yaml:"required"`             // 必填属性
+	AdditionalProperties bool              `yaml:"additionalProperties"` // 是否允许未声明的属性
+	Mode                 string            `yaml:"mode"`                 // 校验失败时：reject（默认）/ strip / warn
+}
+
+// EventDiscovery 未知事件自动发现：不在白名单中的事件记录到待审核列表（不保存事件数据），
+// 由管理员加入白名单，而不是直接返回 403
+type EventDiscovery struct {
+	Enabled    bool
+	MaxPending int `yaml:"maxPending"` // 待审核列表最多记录的事件数量，超出后新的未知事件直接拒绝
 }
 
 // JWT JWT 配置
 type JWT struct {
-	Secret      string `yaml:"secret"`
-	ExpireHours int    `yaml:"expireHours"`
+	Secret             string `yaml:"secret"`
+	ExpireHours        int    `yaml:"expireHours"`        // 登录会话（Refresh Token）有效期
+	AccessTokenMinutes int    `yaml:"accessTokenMinutes"` // Access Token 有效期
+}
+
+// LoginGuard 登录防暴力破解配置（按用户名和 IP 分别统计连续失败次数）
+type LoginGuard struct {
+	FreeAttempts      int `yaml:"freeAttempts"`      // 同一用户名连续失败多少次后开始指数退避
+	LockoutAttempts   int `yaml:"lockoutAttempts"`   // 同一用户名连续失败多少次后临时锁定
+	IPFreeAttempts    int `yaml:"ipFreeAttempts"`    // 同一 IP 连续失败多少次后开始指数退避
+	IPLockoutAttempts int `yaml:"ipLockoutAttempts"` // 同一 IP 连续失败多少次后临时锁定
+	MaxBackoffSeconds int `yaml:"maxBackoffSeconds"` // 退避等待上限（秒）
+	LockoutMinutes    int `yaml:"lockoutMinutes"`    // 锁定时长，也是失败记录的保留时长
+}
+
+// Metrics Prometheus 指标（/metrics）配置，需设置 token 或单独的监听地址
+type Metrics struct {
+	Enabled bool
+	Token   string // 访问时需携带 Authorization: Bearer <token>
+	Addr    string // 单独监听地址（如 127.0.0.1:9100），设置后主端口不提供 /metrics
+}
+
+// RateLimiter 上报限速配置（令牌桶），按客户端 IP 和应用分别限速
+type RateLimiter struct {
+	IPPerMinute  int `yaml:"ipPerMinute"`  // 每个 IP 每分钟补充的令牌数，未配置时使用 rateLimit
+	IPBurst      int `yaml:"ipBurst"`      // 每个 IP 的桶容量（允许的突发请求数），未配置时等于 ipPerMinute
+	AppPerMinute int `yaml:"appPerMinute"` // 每个应用每分钟补充的令牌数
+	AppBurst     int `yaml:"appBurst"`     // 每个应用的桶容量，未配置时等于 appPerMinute
+	MaxEntries   int `yaml:"maxEntries"`   // IP 和应用各自最多跟踪的桶数量，超出后淘汰最久未使用的
+}
+
+// Scrub 上报数据脱敏：写入数据库前替换错误消息、堆栈、URL 和事件 metadata 中的敏感信息
+type Scrub struct {
+	Enabled     bool
+	Detectors   []string // 内置检测规则：email、credit_card、bearer_token、ip_address
+	Patterns    []string // 自定义正则，匹配的内容整体替换
+	DenyKeys    []string `yaml:"denyKeys"` // metadata 和 URL 查询参数中整体替换值的键（不区分大小写）
+	Replacement string   // 替换文本，默认 [Filtered]
+}
+
+// 脱敏规则未配置时的默认值（切片默认值在加载配置后填充，避免与配置文件中的列表合并）
+var (
+	defaultScrubDetectors = []string{"email", "credit_card", "bearer_token", "ip_address"}
+	defaultScrubDenyKeys  = []string{"password", "passwd", "secret", "token", "access_token", "refresh_token", "authorization", "cookie", "api_key", "apikey"}
+)
+
+// SpikeProtection 上报突增保护：应用某类上报每分钟的数量超过基线（滑动平均）的 multiplier 倍
+// 且不少于 minPerMinute 时，按 sampleRate 采样接收，其余丢弃并计数
+type SpikeProtection struct {
+	Enabled         bool
+	Multiplier      float64
+	MinPerMinute    int     `yaml:"minPerMinute"`    // 触发保护的最低每分钟数量，避免低流量应用被误判
+	BaselineMinutes int     `yaml:"baselineMinutes"` // 基线（指数滑动平均）的时间窗口（分钟）
+	SampleRate      float64 `yaml:"sampleRate"`      // 突增期间接收的比例（0-1）
+}
+
+// Ingest 上报写入队列配置：请求校验通过后即返回，由单独的写入协程批量写入数据库
+type Ingest struct {
+	QueueSize       int `yaml:"queueSize"`       // 队列容量，队列满时上报返回 503
+	BatchSize       int `yaml:"batchSize"`       // 每个事务最多写入的条数
+	FlushIntervalMs int `yaml:"flushIntervalMs"` // 不满一批时最长等待多久写入（毫秒）
+}
+
+// OIDC OpenID Connect 单点登录配置
+type OIDC struct {
+	Enabled       bool
+	Issuer        string            // 如 https://idp.example.com/realms/main
+	ClientID      string            `yaml:"clientId"`
+	ClientSecret  string            `yaml:"clientSecret"` // 公共客户端可为空（仅 PKCE）
+	RedirectURL   string            `yaml:"redirectUrl"`  // 如 https://tracely.example.com/auth/oidc/callback
+	Scopes        []string          // 默认 openid profile email
+	UsernameClaim string            `yaml:"usernameClaim"` // 默认 preferred_username
+	GroupsClaim   string            `yaml:"groupsClaim"`   // 默认 groups
+	RoleMappings  []OIDCRoleMapping `yaml:"roleMappings"`
+	DefaultRole   string            `yaml:"defaultRole"`   // 没有匹配的分组时使用的角色，为空表示拒绝登录
+	AutoProvision bool              `yaml:"autoProvision"` // 首次登录时自动创建用户
+}
+
+// OIDCRoleMapping IdP 分组到 Tracely 角色的映射（按顺序取第一个匹配的分组）
+type OIDCRoleMapping struct {
+	Group string
+	Role  string
+	Apps  []string // 允许访问的 AppID，为空表示全部应用
 }
 
 var (
 	configInstance *Config
 	configOnce     sync.Once
+	configFile     string // 首次加载时找到的 config.yaml 路径，热加载时读取同一个文件
 )
 
 // Load 加载配置（环境变量 > config.yaml > 默认值）
 func Load() (*Config, error) {
 	var err error
 	configOnce.Do(func() {
-		configInstance = &Config{
-			Port:         "3001",
-			DBPath:       "./tracely.db",
-			RateLimit:    60,
-			NonceTTL:     300,
-			TimestampTTL: 300,
-			JWT: JWT{
-				Secret:      "default-jwt-secret-change-in-production",
-				ExpireHours: 24,
-			},
+		configInstance, err = load(false)
+		if err == nil {
+			current.Store(configInstance)
 		}
+	})
+	return configInstance, err
+}
 
-		// 尝试读取 config.yaml（支持多个路径）
-		viper.SetConfigName("config")
-		viper.SetConfigType("yaml")
-		viper.AddConfigPath(".")
-		viper.AddConfigPath("./")
-		viper.AddConfigPath("./config")
-		viper.AddConfigPath("/app/config")
+// load 读取并校验配置，reload 为 true 时读取失败直接返回错误（不回退到默认值）
+func load(reload bool) (*Config, error) {
+	cfg := &Config{
+		Port:           "3001",
+		DBPath:         "./tracely.db",
+		RateLimit:      60,
+		NonceTTL:       300,
+		TimestampTTL:   300,
+		RollupInterval: 300,
+		JWT: JWT{
+			Secret:             "default-jwt-secret-change-in-production",
+			ExpireHours:        24,
+			AccessTokenMinutes: 15,
+		},
+		LoginGuard: LoginGuard{
+			FreeAttempts:      3,
+			LockoutAttempts:   10,
+			IPFreeAttempts:    10,
+			IPLockoutAttempts: 50,
+			MaxBackoffSeconds: 60,
+			LockoutMinutes:    15,
+		},
+		RateLimiter: RateLimiter{
+			AppPerMinute: 6000,
+			MaxEntries:   100000,
+		},
+		Scrub: Scrub{
+			Enabled: true,
+		},
+		SpikeProtection: SpikeProtection{
+			Enabled:         true,
+			Multiplier:      10,
+			MinPerMinute:    600,
+			BaselineMinutes: 60,
+			SampleRate:      0.1,
+		},
+		EventDiscovery: EventDiscovery{
+			MaxPending: 1000,
+		},
+		Ingest: Ingest{
+			QueueSize:       10000,
+			BatchSize:       500,
+			FlushIntervalMs: 200,
+		},
+		OIDC: OIDC{
+			Scopes:        []string{"openid", "profile", "email"},
+			UsernameClaim: "preferred_username",
+			GroupsClaim:   "groups",
+			AutoProvision: true,
+		},
+	}
 
-		if err = viper.ReadInConfig(); err == nil {
-			fmt.Printf("[Tracely] Loaded config from: %s\n", viper.ConfigFileUsed())
+	// 读取 config.yaml：首次加载时在多个路径中查找，热加载时读取同一个文件
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if reload {
+		v.SetConfigFile(configFile)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("failed to read config: %w", err)
+		}
+		if err := v.Unmarshal(cfg); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+		}
+	} else {
+		v.SetConfigName("config")
+		v.AddConfigPath(".")
+		v.AddConfigPath("./")
+		v.AddConfigPath("./config")
+		v.AddConfigPath("/app/config")
+
+		if err := v.ReadInConfig(); err == nil {
+			configFile = v.ConfigFileUsed()
+			fmt.Printf("[Tracely] Loaded config from: %s\n", configFile)
 
 			// 加载 YAML 配置
-			if err = viper.Unmarshal(configInstance); err != nil {
+			if err := v.Unmarshal(cfg); err != nil {
 				fmt.Printf("[Tracely] Warning: Failed to unmarshal config: %v\n", err)
-				err = nil // 不中断，使用默认值
+				// 不中断，使用默认值
 			}
 		} else {
 			fmt.Println("[Tracely] No config.yaml found, using environment variables and defaults")
 		}
+	}
 
-		// 环境变量覆盖（优先级更高）
-		if env := os.Getenv("PORT"); env != "" {
-			configInstance.Port = env
-		}
-		if env := os.Getenv("DB_PATH"); env != "" {
-			configInstance.DBPath = env
+	// 环境变量覆盖（优先级更高）
+	if env := os.Getenv("PORT"); env != "" {
+		cfg.Port = env
+	}
+	if env := os.Getenv("DB_PATH"); env != "" {
+		cfg.DBPath = env
+	}
+	if env := os.Getenv("RATE_LIMIT"); env != "" {
+		fmt.Sscanf(env, "%d", &cfg.RateLimit)
+	}
+	if env := os.Getenv("NONCE_TTL"); env != "" {
+		fmt.Sscanf(env, "%d", &cfg.NonceTTL)
+	}
+	if env := os.Getenv("TIMESTAMP_TTL"); env != "" {
+		fmt.Sscanf(env, "%d", &cfg.TimestampTTL)
+	}
+	if env := os.Getenv("ROLLUP_INTERVAL"); env != "" {
+		fmt.Sscanf(env, "%d", &cfg.RollupInterval)
+	}
+	if env := os.Getenv("METRICS_TOKEN"); env != "" {
+		cfg.Metrics.Token = env
+	}
+
+	// 验证配置
+	for _, user := range cfg.Users {
+		if user.Role != "" && !IsValidRole(user.Role) {
+			return nil, fmt.Errorf("invalid role %q for user %s", user.Role, user.Username)
 		}
-		if env := os.Getenv("RATE_LIMIT"); env != "" {
-			fmt.Sscanf(env, "%d", &configInstance.RateLimit)
+	}
+
+	for _, proxy := range cfg.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
 		}
-		if env := os.Getenv("NONCE_TTL"); env != "" {
-			fmt.Sscanf(env, "%d", &configInstance.NonceTTL)
+	}
+
+	origins, invalid, ok := NormalizeOrigins(cfg.DashboardOrigins)
+	if !ok {
+		return nil, fmt.Errorf("invalid dashboard origin %q", invalid)
+	}
+	cfg.DashboardOrigins = origins
+
+	if g := cfg.LoginGuard; g.FreeAttempts < 1 || g.LockoutAttempts <= g.FreeAttempts ||
+		g.IPFreeAttempts < 1 || g.IPLockoutAttempts <= g.IPFreeAttempts ||
+		g.MaxBackoffSeconds < 1 || g.LockoutMinutes < 1 {
+		return nil, fmt.Errorf("invalid loginGuard: lockout attempts must exceed free attempts and durations must be positive")
+	}
+
+	if cfg.Scrub.Detectors == nil {
+		cfg.Scrub.Detectors = defaultScrubDetectors
+	}
+	if cfg.Scrub.DenyKeys == nil {
+		cfg.Scrub.DenyKeys = defaultScrubDenyKeys
+	}
+	for _, name := range cfg.Scrub.Detectors {
+		if !scrub.IsDetector(name) {
+			return nil, fmt.Errorf("invalid scrub detector %q", name)
 		}
-		if env := os.Getenv("TIMESTAMP_TTL"); env != "" {
-			fmt.Sscanf(env, "%d", &configInstance.TimestampTTL)
+	}
+	for _, pattern := range cfg.Scrub.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid scrub pattern %q: %w", pattern, err)
 		}
+	}
 
-		// 验证配置
-		if len(configInstance.Apps) == 0 {
-			fmt.Println("[Tracely] Warning: No apps configured in config.yaml")
-		} else {
-			fmt.Printf("[Tracely] Loaded %d apps from config\n", len(configInstance.Apps))
-		}
+	// 限速未单独配置的项使用兼容值
+	rl := &cfg.RateLimiter
+	if rl.IPPerMinute == 0 {
+		rl.IPPerMinute = cfg.RateLimit
+	}
+	if rl.IPBurst == 0 {
+		rl.IPBurst = rl.IPPerMinute
+	}
+	if rl.AppBurst == 0 {
+		rl.AppBurst = rl.AppPerMinute
+	}
+	if rl.IPPerMinute < 1 || rl.AppPerMinute < 1 || rl.IPBurst < 1 || rl.AppBurst < 1 || rl.MaxEntries < 1 {
+		return nil, fmt.Errorf("invalid rateLimiter: rates, bursts and maxEntries must be positive")
+	}
 
-		if len(configInstance.Users) == 0 {
-			fmt.Println("[Tracely] Warning: No users configured in config.yaml")
-		} else {
-			fmt.Printf("[Tracely] Loaded %d users from config\n", len(configInstance.Users))
-		}
-	})
+	if sp := cfg.SpikeProtection; sp.Enabled && (sp.Multiplier <= 1 || sp.MinPerMinute < 1 ||
+		sp.BaselineMinutes < 1 || sp.SampleRate <= 0 || sp.SampleRate > 1) {
+		return nil, fmt.Errorf("invalid spikeProtection: multiplier must exceed 1 and sampleRate must be in (0, 1]")
+	}
 
-	return configInstance, err
-}
+	if d := cfg.EventDiscovery; d.Enabled && d.MaxPending < 1 {
+		return nil, fmt.Errorf("invalid eventDiscovery: maxPending must be positive")
+	}
 
-// GetSecret 根据 AppID 获取 Secret
-func (c *Config) GetSecret(appID string) (string, bool) {
-	for _, app := range c.Apps {
-		if app.AppID == appID {
-			return app.AppSecret, true
+	for _, app := range cfg.Apps {
+		if q := app.Quota; q.DailyEvents < 0 || q.MonthlyEvents < 0 || q.DailyErrors < 0 || q.MonthlyErrors < 0 {
+			return nil, fmt.Errorf("invalid quota for app %s: quotas must not be negative", app.AppID)
 		}
 	}
-	return "", false
-}
 
-// GetUser 根据用户名获取用户
-func (c *Config) GetUser(username string) (User, bool) {
-	for _, user := range c.Users {
-		if user.Username == username {
-			return user, true
-		}
+	if q := cfg.Ingest; q.QueueSize < 1 || q.BatchSize < 1 || q.FlushIntervalMs < 1 {
+		return nil, fmt.Errorf("invalid ingest: queueSize, batchSize and flushIntervalMs must be positive")
 	}
-	return User{}, false
-}
 
-// VerifyPassword 验证密码
-func (u *User) VerifyPassword(password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password))
-	return err == nil
-}
+	if m := cfg.Metrics; m.Enabled && m.Token == "" && m.Addr == "" {
+		return nil, fmt.Errorf("metrics requires a token or a separate addr")
+	}
 
-// IsEventAllowed 检查事件是否在白名单中
-func (c *Config) IsEventAllowed(eventName string) bool {
-	for _, event := range c.Events {
-		if event.EventName == eventName {
-			return true
+	if oidc := cfg.OIDC; oidc.Enabled {
+		if oidc.Issuer == "" || oidc.ClientID == "" || oidc.RedirectURL == "" {
+			return nil, fmt.Errorf("oidc requires issuer, clientId and redirectUrl")
 		}
+		if oidc.DefaultRole != "" && !IsValidRole(oidc.DefaultRole) {
+			return nil, fmt.Errorf("invalid oidc default role %q", oidc.DefaultRole)
+		}
+		for _, m := range oidc.RoleMappings {
+			if !IsValidRole(m.Role) {
+				return nil, fmt.Errorf("invalid role %q for oidc group %s", m.Role, m.Group)
+			}
+		}
+	} else if cfg.DisablePasswordLogin {
+		return nil, fmt.Errorf("disablePasswordLogin requires oidc to be enabled")
 	}
-	return false
-}
 
-// GetEventConfig 获取事件配置
-func (c *Config) GetEventConfig(eventName string) (EventConfig, bool) {
-	for _, event := range c.Events {
-		if event.EventName == eventName {
-			return event, true
+	// 热加载时不重复输出导入提示
+	if !reload {
+		if len(cfg.Apps) == 0 {
+			fmt.Println("[Tracely] Warning: No apps configured in config.yaml")
+		} else {
+			fmt.Printf("[Tracely] Loaded %d apps from config\n", len(cfg.Apps))
+		}
+		if len(cfg.Users) == 0 {
+			fmt.Println("[Tracely] Warning: No users configured in config.yaml")
+		} else {
+			fmt.Printf("[Tracely] Loaded %d users from config\n", len(cfg.Users))
 		}
 	}
-	return EventConfig{}, false
+	return cfg, nil
+}
+
+// IsValidRole 检查角色是否合法
+func IsValidRole(role string) bool {
+	return role == RoleAdmin || role == RoleMember || role == RoleViewer
 }
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"net/url"
+	"strings"
+)
+
+// NormalizeOrigin 规范化来源为 scheme://host[:port]（小写、不含路径），* 表示允许任意来源
+func NormalizeOrigin(origin string) (string, bool) {
+	origin = strings.TrimSpace(origin)
+	if origin == "*" {
+		return origin, true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		strings.TrimSuffix(u.Path, "/") != "" || u.RawQuery != "" || u.User != nil {
+		return "", false
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), true
+}
+
+// NormalizeOrigins 规范化来源列表，返回第一个不合法的来源
+func NormalizeOrigins(origins []string) ([]string, string, bool) {
+	if origins == nil {
+		return nil, "", true
+	}
+	normalized := make([]string, 0, len(origins))
+	for _, o := range origins {
+		origin, ok := NormalizeOrigin(o)
+		if !ok {
+			return nil, o, false
+		}
+		normalized = append(normalized, origin)
+	}
+	return normalized, "", true
+}
@@ -0,0 +1,244 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// 未生效的配置项变更原因
+const (
+	NoteRestart  = "requires restart"             // 需要重启才能生效
+	NoteDatabase = "managed in database, ignored" // 导入后以数据库为准，通过管理接口修改
+)
+
+// restartKeys 启动时使用、热加载不生效的配置项
+var restartKeys = []string{"port", "dbPath", "rollupInterval", "jwt.secret", "oidc", "metrics", "ingest", "trustedProxies"}
+
+// databaseKeys 首次启动导入数据库、之后以数据库为准的配置项
+var databaseKeys = []string{"apps", "users", "events"}
+
+// maskedKeys 变更日志中隐藏取值的配置项（按最后一级名称匹配）
+var maskedKeys = map[string]bool{
+	"secret": true, "appSecret": true, "clientSecret": true, "token": true, "passwordHash": true, "totpSecret": true,
+}
+
+var (
+	current   atomic.Pointer[Config]
+	reloadMu  sync.Mutex
+	listeners []func(old, cur *Config)
+)
+
+// Current 获取当前生效的配置（热加载后为新配置），调用方不应修改返回值
+func Current() *Config {
+	return current.Load()
+}
+
+// OnReload 注册热加载回调，新配置发布后按注册顺序调用
+func OnReload(fn func(old, cur *Config)) {
+	reloadMu.Lock()
+	listeners = append(listeners, fn)
+	reloadMu.Unlock()
+}
+
+// Change 一项配置变更，Note 不为空表示该项未生效
+type Change struct {
+	Key  string
+	Old  string
+	New  string
+	Note string
+}
+
+// Reload 重新读取 config.yaml，校验通过后替换当前配置并通知回调，返回变更的配置项
+// 校验失败时保持当前配置；需要重启或以数据库为准的配置项保留原值
+func Reload() ([]Change, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	if configFile == "" {
+		return nil, fmt.Errorf("no config file loaded")
+	}
+	next, err := load(true)
+	if err != nil {
+		return nil, err
+	}
+
+	old := current.Load()
+	changes := diff(old, next)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	keepUnreloadable(old, next)
+
+	current.Store(next)
+	for _, fn := range listeners {
+		fn(old, next)
+	}
+	return changes, nil
+}
+
+// ReloadAndLog 热加载配置并记录变更，trigger 为触发原因（如 SIGHUP、文件变化）
+func ReloadAndLog(trigger string) {
+	changes, err := Reload()
+	if err != nil {
+		slog.Error("[Tracely] Config reload failed, keeping current config", "trigger", trigger, "error", err)
+		return
+	}
+	if len(changes) == 0 {
+		slog.Info("[Tracely] Config reloaded, nothing changed", "trigger", trigger)
+		return
+	}
+	slog.Info("[Tracely] Config reloaded", "trigger", trigger, "changes", len(changes))
+	for _, c := range changes {
+		if c.Note != "" {
+			slog.Warn("[Tracely] Config change not applied", "key", c.Key, "old", c.Old, "new", c.New, "reason", c.Note)
+		} else {
+			slog.Info("[Tracely] Config changed", "key", c.Key, "old", c.Old, "new", c.New)
+		}
+	}
+}
+
+// Watch 监听 config.yaml 的变化并自动热加载（未找到配置文件时不监听）
+func Watch() {
+	if configFile == "" {
+		return
+	}
+	v := viper.New()
+	v.SetConfigFile(configFile)
+	v.OnConfigChange(func(e fsnotify.Event) {
+		ReloadAndLog("file change")
+	})
+	v.WatchConfig()
+}
+
+// keepUnreloadable 新配置中热加载不生效的配置项沿用原值，使 Current 与实际运行状态一致
+func keepUnreloadable(old, next *Config) {
+	next.Port, next.DBPath = old.Port, old.DBPath
+	next.RollupInterval = old.RollupInterval
+	next.JWT.Secret = old.JWT.Secret
+	next.OIDC, next.Metrics, next.Ingest = old.OIDC, old.Metrics, old.Ingest
+	next.TrustedProxies = old.TrustedProxies
+	next.Apps, next.Users, next.Events = old.Apps, old.Users, old.Events
+}
+
+// diff 比较两份配置，返回按配置项排序的变更
+func diff(old, next *Config) []Change {
+	before, after := make(map[string]string), make(map[string]string)
+	flatten("", reflect.ValueOf(*old), before)
+	flatten("", reflect.ValueOf(*next), after)
+
+	keys := make([]string, 0, len(after))
+	for key := range after {
+		keys = append(keys, key)
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var changes []Change
+	for _, key := range keys {
+		o, inOld := before[key]
+		n, inNew := after[key]
+		if o == n {
+			continue
+		}
+		if !inOld || !inNew {
+			// 列表增减的元素已体现在列表数量的变化中
+			if strings.Contains(key, "[") {
+				continue
+			}
+			o, n = valueOrUnset(o, inOld), valueOrUnset(n, inNew)
+		}
+		if maskedKeys[key[strings.LastIndex(key, ".")+1:]] {
+			o, n = "******", "******"
+		}
+		changes = append(changes, Change{Key: key, Old: o, New: n, Note: changeNote(key)})
+	}
+	return changes
+}
+
+// valueOrUnset 配置项不存在时显示为 <unset>
+func valueOrUnset(v string, ok bool) string {
+	if !ok {
+		return "<unset>"
+	}
+	return v
+}
+
+// changeNote 配置项变更不生效的原因
+func changeNote(key string) string {
+	for _, k := range restartKeys {
+		if hasKeyPrefix(key, k) {
+			return NoteRestart
+		}
+	}
+	for _, k := range databaseKeys {
+		if hasKeyPrefix(key, k) {
+			return NoteDatabase
+		}
+	}
+	return ""
+}
+
+// hasKeyPrefix key 是否为 prefix 或其下级配置项
+func hasKeyPrefix(key, prefix string) bool {
+	return key == prefix || strings.HasPrefix(key, prefix+".") || strings.HasPrefix(key, prefix+"[")
+}
+
+// flatten 将配置展开为 配置项 -> 取值（结构体和结构体切片逐级展开）
+func flatten(prefix string, v reflect.Value, out map[string]string) {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			key := fieldKey(t.Field(i))
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			flatten(key, v.Field(i), out)
+		}
+	case reflect.Pointer:
+		if v.IsNil() {
+			out[prefix] = "<nil>"
+			return
+		}
+		flatten(prefix, v.Elem(), out)
+	case reflect.Slice:
+		if elem := v.Type().Elem(); elem.Kind() != reflect.Struct && elem.Kind() != reflect.Pointer {
+			out[prefix] = fmt.Sprint(v.Interface())
+			return
+		}
+		out[prefix] = fmt.Sprintf("%d items", v.Len())
+		for i := 0; i < v.Len(); i++ {
+			flatten(fmt.Sprintf("%s[%d]", prefix, i), v.Index(i), out)
+		}
+	default:
+		out[prefix] = fmt.Sprint(v.Interface())
+	}
+}
+
+// fieldKey 配置项名称：优先使用 yaml 标签，否则为首字母小写的字段名（如 DBPath -> dbPath）
+func fieldKey(f reflect.StructField) string {
+	if tag := f.Tag.Get("yaml"); tag != "" {
+		return strings.Split(tag, ",")[0]
+	}
+	runes := []rune(f.Name)
+	for i := range runes {
+		if i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+			break
+		}
+		runes[i] = unicode.ToLower(runes[i])
+	}
+	return string(runes)
+}
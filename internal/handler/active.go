@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanxi/tracely/internal/model"
+	"gorm.io/gorm"
+)
+
+// tagKeyPattern 标签名只允许字母、数字、下划线和中划线，避免拼接 JSON 路径时出错
+var tagKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// parseDateRange 解析 startDate/endDate 参数（格式 2006-01-02，含首尾）
+// 缺省时取最近 defaultDays 天，区间最长 366 天
+func parseDateRange(c *gin.Context, defaultDays int) (time.Time, time.Time, bool) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	end := today
+	start := today.AddDate(0, 0, -(defaultDays - 1))
+
+	if s := c.Query("endDate"); s != "" {
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+		end = t
+		start = end.AddDate(0, 0, -(defaultDays - 1))
+	}
+	if s := c.Query("startDate"); s != "" {
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+		start = t
+	}
+
+	if start.After(end) || end.Sub(start) > 365*24*time.Hour {
+		return time.Time{}, time.Time{}, false
+	}
+	return start, end, true
+}
+
+// GetActiveUsers 获取 DAU/WAU/MAU 及粘性趋势接口
+func GetActiveUsers(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		appID := c.Query("appID")
+
+		start, end, ok := parseDateRange(c, 30)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "日期参数错误"})
+			return
+		}
+
+		points, err := model.GetActiveUsers(db, appID, start, end)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"series": points})
+	}
+}
+
+// GetActiveInstances 获取实例在线趋势和实例列表接口（基于 Go SDK 心跳）
+func GetActiveInstances(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		interval := c.DefaultQuery("interval", "hour")
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		tagKey := c.Query("tagKey")
+
+		if interval != "hour" && interval != "day" {
+			interval = "hour"
+		}
+		if limit < 1 || limit > 500 {
+			limit = 50
+		}
+		if tagKey != "" && !tagKeyPattern.MatchString(tagKey) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "标签名不合法"})
+			return
+		}
+
+		start, end, ok := parseDateRange(c, 1)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "日期参数错误"})
+			return
+		}
+
+		filter := model.InstanceFilter{
+			AppID:    c.Query("appID"),
+			Start:    start,
+			End:      end.AddDate(0, 0, 1),
+			TagKey:   tagKey,
+			TagValue: c.Query("tagValue"),
+		}
+
+		series, err := model.GetConcurrentInstances(db, filter, interval)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			return
+		}
+		instances, err := model.GetInstances(db, filter, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			return
+		}
+
+		// 确保返回空数组而不是 null
+		if series == nil {
+			series = []model.InstancePoint{}
+		}
+		if instances == nil {
+			instances = []model.InstanceSummary{}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"series":    series,
+			"instances": instances,
+		})
+	}
+}
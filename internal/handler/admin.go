@@ -0,0 +1,614 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanxi/tracely/internal/config"
+	"github.com/hanxi/tracely/internal/model"
+	"github.com/hanxi/tracely/internal/store"
+)
+
+// minPasswordLength 管理接口设置密码的最小长度
+const minPasswordLength = 8
+
+// respondAdminError 将 store 的业务错误转换为 HTTP 响应
+func respondAdminError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, store.ErrConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, store.ErrLastAdmin), errors.Is(err, store.ErrLastSecret):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "操作失败"})
+	}
+}
+
+// AdminAppRequest 创建/修改应用请求
+type AdminAppRequest struct {
+	AppID          string          `json:"appId"` // 仅创建时有效，为空自动生成
+	AppName        string          `json:"appName" binding:"required"`
+	AllowedOrigins *[]string       `json:"allowedOrigins"` // 浏览器 SDK 允许的来源，为空表示不限制，修改时不传表示不修改
+	Quota          *model.AppQuota `json:"quota"`          // 每日/每月上报配额，0 表示不限制，修改时不传表示不修改
+	Scrub          *model.AppScrub `json:"scrub"`          // 覆盖全局脱敏规则，修改时不传表示不修改
+}
+
+// input 校验来源、配额和脱敏规则并转换为 store 参数
+func (r *AdminAppRequest) input(st *store.Store) (store.AppInput, string) {
+	input := store.AppInput{AppID: r.AppID, AppName: r.AppName, Quota: r.Quota, Scrub: r.Scrub}
+	if r.Quota != nil && !r.Quota.Valid() {
+		return input, "配额不能为负数"
+	}
+	if r.Scrub != nil {
+		if err := st.ValidateAppScrub(*r.Scrub); err != nil {
+			return input, "无效的脱敏规则：" + err.Error()
+		}
+	}
+	if r.AllowedOrigins != nil {
+		origins, invalid, ok := config.NormalizeOrigins(*r.AllowedOrigins)
+		if !ok {
+			return input, "无效的来源：" + invalid + "（格式如 https://example.com）"
+		}
+		if origins == nil {
+			origins = []string{}
+		}
+		input.AllowedOrigins = origins
+	}
+	return input, ""
+}
+
+// AdminListApps 获取应用列表（管理）
+func AdminListApps(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"apps": st.Apps()})
+	}
+}
+
+// AdminCreateApp 创建应用，返回自动生成的 Secret（仅此一次）
+func AdminCreateApp(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req AdminAppRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+
+		input, msg := req.input(st)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		app, secret, err := st.CreateApp(input)
+		if err != nil {
+			respondAdminError(c, err)
+			return
+		}
+
+		audit(c, st, model.AuditAppCreate, app.AppID, gin.H{"appName": app.AppName, "allowedOrigins": app.AllowedOrigins, "quota": app.Quota, "scrub": app.Scrub})
+		c.JSON(http.StatusOK, gin.H{
+			"app":       app,
+			"secret":    secret,
+			"appSecret": secret.Secret,
+		})
+	}
+}
+
+// AdminUpdateApp 修改应用
+func AdminUpdateApp(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req AdminAppRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+
+		input, msg := req.input(st)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		app, err := st.UpdateApp(c.Param("appId"), input)
+		if err != nil {
+			respondAdminError(c, err)
+			return
+		}
+
+		audit(c, st, model.AuditAppUpdate, app.AppID, gin.H{"appName": app.AppName, "allowedOrigins": app.AllowedOrigins, "quota": app.Quota, "scrub": app.Scrub})
+		c.JSON(http.StatusOK, gin.H{"app": app})
+	}
+}
+
+// AdminDeleteApp 删除应用
+func AdminDeleteApp(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := st.DeleteApp(c.Param("appId")); err != nil {
+			respondAdminError(c, err)
+			return
+		}
+		audit(c, st, model.AuditAppDelete, c.Param("appId"), nil)
+		c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+	}
+}
+
+// AdminSecretRequest 添加/修改应用密钥请求
+type AdminSecretRequest struct {
+	Name      string     `json:"name"`
+	ExpiresAt *time.Time `json:"expiresAt"` // 为空表示永不过期
+}
+
+// parseSecretID 解析路径中的密钥 ID
+func parseSecretID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("secretId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的密钥 ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// AdminListAppSecrets 获取应用的密钥列表及各密钥使用次数
+func AdminListAppSecrets(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		secrets, err := st.AppSecrets(c.Param("appId"))
+		if err != nil {
+			respondAdminError(c, err)
+			return
+		}
+
+		now := time.Now()
+		list := make([]gin.H, 0, len(secrets))
+		for _, secret := range secrets {
+			list = append(list, gin.H{
+				"id":         secret.ID,
+				"name":       secret.Name,
+				"active":     secret.IsActive(now),
+				"expiresAt":  secret.ExpiresAt,
+				"revokedAt":  secret.RevokedAt,
+				"usageCount": secret.UsageCount,
+				"lastUsedAt": secret.LastUsedAt,
+				"createdAt":  secret.CreatedAt,
+			})
+		}
+		c.JSON(http.StatusOK, gin.H{"secrets": list})
+	}
+}
+
+// AdminCreateAppSecret 为应用添加新密钥，返回 Secret（仅此一次），旧密钥继续有效
+func AdminCreateAppSecret(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req AdminSecretRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "过期时间必须晚于当前时间"})
+			return
+		}
+
+		secret, err := st.AddAppSecret(c.Param("appId"), store.SecretInput{Name: req.Name, ExpiresAt: req.ExpiresAt})
+		if err != nil {
+			respondAdminError(c, err)
+			return
+		}
+
+		audit(c, st, model.AuditAppSecretCreate, c.Param("appId"), gin.H{"secretId": secret.ID, "name": secret.Name, "expiresAt": secret.ExpiresAt})
+		c.JSON(http.StatusOK, gin.H{
+			"secret":    secret,
+			"appSecret": secret.Secret,
+		})
+	}
+}
+
+// AdminUpdateAppSecret 修改密钥备注和过期时间（如给旧密钥设置淘汰期限）
+func AdminUpdateAppSecret(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := parseSecretID(c)
+		if !ok {
+			return
+		}
+		var req AdminSecretRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+
+		secret, err := st.UpdateAppSecret(c.Param("appId"), id, store.SecretInput{Name: req.Name, ExpiresAt: req.ExpiresAt})
+		if err != nil {
+			respondAdminError(c, err)
+			return
+		}
+		audit(c, st, model.AuditAppSecretUpdate, c.Param("appId"), gin.H{"secretId": secret.ID, "name": secret.Name, "expiresAt": secret.ExpiresAt})
+
+		c.JSON(http.StatusOK, gin.H{"secret": secret})
+	}
+}
+
+// AdminRevokeAppSecret 吊销密钥（客户端迁移到新密钥后调用）
+func AdminRevokeAppSecret(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := parseSecretID(c)
+		if !ok {
+			return
+		}
+
+		secret, err := st.RevokeAppSecret(c.Param("appId"), id)
+		if err != nil {
+			respondAdminError(c, err)
+			return
+		}
+		audit(c, st, model.AuditAppSecretRevoke, c.Param("appId"), gin.H{"secretId": secret.ID, "name": secret.Name})
+
+		c.JSON(http.StatusOK, gin.H{"secret": secret})
+	}
+}
+
+// AdminUserRequest 创建/修改用户请求
+type AdminUserRequest struct {
+	Username     string    `json:"username"`     // 仅创建时有效
+	Password     string    `json:"password"`     // 修改时为空表示不修改
+	Role         string    `json:"role"`         // 修改时为空表示不修改
+	Apps         *[]string `json:"apps"`         // 修改时不传表示不修改
+	TOTPRequired *bool     `json:"totpRequired"` // 强制启用两步验证，修改时不传表示不修改
+	OIDCSubject  *string   `json:"oidcSubject"`  // 关联的单点登录账号（IdP 的 sub），空字符串表示取消关联，不传表示不修改
+}
+
+// validate 校验请求参数，creating 表示创建用户
+func (r *AdminUserRequest) validate(creating bool) string {
+	if creating && r.Username == "" {
+		return "用户名不能为空"
+	}
+	if (creating || r.Password != "") && len(r.Password) < minPasswordLength {
+		return "密码长度不能少于 8 位"
+	}
+	if (creating || r.Role != "") && !config.IsValidRole(r.Role) {
+		return "角色只能是 admin、member 或 viewer"
+	}
+	if r.OIDCSubject != nil && *r.OIDCSubject != "" && !config.Current().OIDC.Enabled {
+		return "未启用单点登录，不能关联单点登录账号"
+	}
+	return ""
+}
+
+// input 转换为 store 参数
+func (r *AdminUserRequest) input() store.UserInput {
+	input := store.UserInput{Username: r.Username, Password: r.Password, Role: r.Role, TOTPRequired: r.TOTPRequired}
+	if r.OIDCSubject != nil {
+		input.OIDCIssuer, input.OIDCSubject = config.Current().OIDC.Issuer, r.OIDCSubject
+	}
+	if r.Apps != nil {
+		input.Apps = *r.Apps
+		if input.Apps == nil {
+			input.Apps = []string{}
+		}
+	}
+	return input
+}
+
+// auditDetails 审计日志记录的修改内容（不记录密码，只记录是否修改）
+func (r *AdminUserRequest) auditDetails() gin.H {
+	details := gin.H{}
+	if r.Role != "" {
+		details["role"] = r.Role
+	}
+	if r.Apps != nil {
+		details["apps"] = *r.Apps
+	}
+	if r.TOTPRequired != nil {
+		details["totpRequired"] = *r.TOTPRequired
+	}
+	if r.OIDCSubject != nil {
+		details["oidcSubject"] = *r.OIDCSubject
+	}
+	if r.Password != "" {
+		details["passwordChanged"] = true
+	}
+	return details
+}
+
+// AdminListUsers 获取用户列表
+func AdminListUsers(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"users": st.Users()})
+	}
+}
+
+// AdminCreateUser 创建用户
+func AdminCreateUser(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req AdminUserRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+		if msg := req.validate(true); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		user, err := st.CreateUser(req.input())
+		if err != nil {
+			respondAdminError(c, err)
+			return
+		}
+		audit(c, st, model.AuditUserCreate, user.Username, req.auditDetails())
+
+		c.JSON(http.StatusOK, gin.H{"user": user})
+	}
+}
+
+// AdminUpdateUser 修改用户
+func AdminUpdateUser(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req AdminUserRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+		if msg := req.validate(false); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		user, err := st.UpdateUser(c.Param("username"), req.input())
+		if err != nil {
+			respondAdminError(c, err)
+			return
+		}
+		audit(c, st, model.AuditUserUpdate, user.Username, req.auditDetails())
+
+		c.JSON(http.StatusOK, gin.H{"user": user})
+	}
+}
+
+// AdminDeleteUser 删除用户
+func AdminDeleteUser(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := st.DeleteUser(c.Param("username")); err != nil {
+			respondAdminError(c, err)
+			return
+		}
+		audit(c, st, model.AuditUserDelete, c.Param("username"), nil)
+		c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+	}
+}
+
+// AdminListUserSessions 获取用户的登录会话
+func AdminListUserSessions(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessions, err := st.ListUserSessions(c.Param("username"))
+		if err != nil {
+			respondAdminError(c, err)
+			return
+		}
+
+		now := time.Now()
+		list := make([]gin.H, 0, len(sessions))
+		for _, session := range sessions {
+			list = append(list, gin.H{
+				"sessionId":  session.SessionID,
+				"active":     session.IsActive(now),
+				"userAgent":  session.UserAgent,
+				"ip":         session.IP,
+				"expiresAt":  session.ExpiresAt,
+				"revokedAt":  session.RevokedAt,
+				"lastUsedAt": session.LastUsedAt,
+				"createdAt":  session.CreatedAt,
+			})
+		}
+		c.JSON(http.StatusOK, gin.H{"sessions": list})
+	}
+}
+
+// AdminRevokeUserSessions 吊销用户的全部登录会话（如设备丢失），已签发的 Access Token 同时失效
+func AdminRevokeUserSessions(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username := c.Param("username")
+		if _, ok := st.GetUser(username); !ok {
+			respondAdminError(c, store.ErrNotFound)
+			return
+		}
+
+		count, err := st.RevokeUserSessions(username)
+		if err != nil {
+			respondAdminError(c, err)
+			return
+		}
+
+		audit(c, st, model.AuditUserRevokeSessions, username, gin.H{"revoked": count})
+		c.JSON(http.StatusOK, gin.H{"revoked": count})
+	}
+}
+
+// AdminResetUserTOTP 重置用户的两步验证（用户丢失认证器且没有恢复码时），下次登录需重新绑定
+func AdminResetUserTOTP(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := st.DisableTOTP(c.Param("username"), true); err != nil {
+			respondAdminError(c, err)
+			return
+		}
+		audit(c, st, model.AuditUserResetTOTP, c.Param("username"), nil)
+		c.JSON(http.StatusOK, gin.H{"message": "已重置两步验证"})
+	}
+}
+
+// AdminEventRequest 创建/修改事件定义请求
+type AdminEventRequest struct {
+	EventName     string          `json:"eventName"` // 仅创建时有效，match 为 glob / regex 时为匹配模式
+	Match         string          `json:"match"`     // 匹配方式：exact（默认）/ glob / regex，仅创建时有效
+	Description   string          `json:"description"`
+	RetentionDays int             `json:"retentionDays"`
+	Schema        json.RawMessage `json:"schema"` // metadata 结构约束；修改时不传表示不修改，传 null 表示取消校验
+}
+
+// schema 解析并校验 metadata 结构约束，未传或为 null 时返回 nil
+func (r AdminEventRequest) schema() (*model.MetadataSchema, error) {
+	if len(r.Schema) == 0 || string(r.Schema) == "null" {
+		return nil, nil
+	}
+	var schema model.MetadataSchema
+	if err := json.Unmarshal(r.Schema, &schema); err != nil {
+		return nil, err
+	}
+	if err := schema.Check(); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// eventAuditDetails 事件定义的审计日志详情
+func eventAuditDetails(event *model.EventDefinition) gin.H {
+	return gin.H{"match": event.Match, "description": event.Description, "retentionDays": event.RetentionDays, "schema": event.Schema}
+}
+
+// AdminListEvents 获取事件白名单（含 metadata 校验失败次数）
+func AdminListEvents(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		events, err := st.ListEvents()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"events": events})
+	}
+}
+
+// AdminCreateEvent 添加事件到白名单
+func AdminCreateEvent(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req AdminEventRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.EventName == "" || req.RetentionDays < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+		schema, err := req.schema()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "schema 无效: " + err.Error()})
+			return
+		}
+		if _, err := (&model.EventDefinition{EventName: req.EventName, Match: req.Match}).CompilePattern(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "匹配模式无效: " + err.Error()})
+			return
+		}
+
+		event, err := st.CreateEvent(store.EventInput{
+			EventName:     req.EventName,
+			Match:         req.Match,
+			Description:   req.Description,
+			RetentionDays: req.RetentionDays,
+			Schema:        schema,
+		})
+		if err != nil {
+			respondAdminError(c, err)
+			return
+		}
+		audit(c, st, model.AuditEventCreate, event.EventName, eventAuditDetails(event))
+
+		c.JSON(http.StatusOK, gin.H{"event": event})
+	}
+}
+
+// AdminUpdateEvent 修改事件定义
+func AdminUpdateEvent(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req AdminEventRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.RetentionDays < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+		schema, err := req.schema()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "schema 无效: " + err.Error()})
+			return
+		}
+
+		event, err := st.UpdateEvent(c.Param("eventName"), store.EventInput{
+			Description:   req.Description,
+			RetentionDays: req.RetentionDays,
+			Schema:        schema,
+			UpdateSchema:  len(req.Schema) > 0,
+		})
+		if err != nil {
+			respondAdminError(c, err)
+			return
+		}
+		audit(c, st, model.AuditEventUpdate, event.EventName, eventAuditDetails(event))
+
+		c.JSON(http.StatusOK, gin.H{"event": event})
+	}
+}
+
+// AdminDeleteEvent 从白名单移除事件
+func AdminDeleteEvent(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := st.DeleteEvent(c.Param("eventName")); err != nil {
+			respondAdminError(c, err)
+			return
+		}
+		audit(c, st, model.AuditEventDelete, c.Param("eventName"), nil)
+		c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+	}
+}
+
+// AdminListPendingEvents 获取自动发现的待审核事件
+func AdminListPendingEvents(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		events, err := st.ListPendingEvents()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"events": events})
+	}
+}
+
+// AdminApprovePendingEvent 将待审核事件加入白名单（可同时设置描述、保留天数和 schema）
+func AdminApprovePendingEvent(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 请求体可为空
+		var req AdminEventRequest
+		if err := c.ShouldBindJSON(&req); (err != nil && !errors.Is(err, io.EOF)) || req.RetentionDays < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+		schema, err := req.schema()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "schema 无效: " + err.Error()})
+			return
+		}
+
+		event, err := st.ApprovePendingEvent(c.Param("eventName"), store.EventInput{
+			Description:   req.Description,
+			RetentionDays: req.RetentionDays,
+			Schema:        schema,
+		})
+		if err != nil {
+			respondAdminError(c, err)
+			return
+		}
+		audit(c, st, model.AuditPendingEventApprove, event.EventName, eventAuditDetails(event))
+
+		c.JSON(http.StatusOK, gin.H{"event": event})
+	}
+}
+
+// AdminDismissPendingEvent 从待审核列表移除事件
+func AdminDismissPendingEvent(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := st.DismissPendingEvent(c.Param("eventName")); err != nil {
+			respondAdminError(c, err)
+			return
+		}
+		audit(c, st, model.AuditPendingEventDismiss, c.Param("eventName"), nil)
+		c.JSON(http.StatusOK, gin.H{"message": "已移除"})
+	}
+}
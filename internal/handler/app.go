@@ -2,9 +2,11 @@ package handler
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/hanxi/tracely/internal/config"
+	"github.com/hanxi/tracely/internal/middleware"
+	"github.com/hanxi/tracely/internal/store"
 )
 
 // AppInfo 应用信息（返回给前端，不包含敏感信息）
@@ -13,12 +15,16 @@ type AppInfo struct {
 	AppName string `json:"appName"`
 }
 
-// GetApps 获取应用列表
-func GetApps(cfg *config.Config) gin.HandlerFunc {
+// GetApps 获取应用列表（只返回当前用户有权访问的应用）
+func GetApps(st *store.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 构建应用列表（不包含 appSecret）
-		appList := make([]AppInfo, 0, len(cfg.Apps))
-		for _, app := range cfg.Apps {
+		apps := st.Apps()
+		appList := make([]AppInfo, 0, len(apps))
+		for _, app := range apps {
+			if !middleware.CanAccessApp(c, app.AppID) {
+				continue
+			}
 			appList = append(appList, AppInfo{
 				AppID:   app.AppID,
 				AppName: app.AppName,
@@ -30,3 +36,20 @@ func GetApps(cfg *config.Config) gin.HandlerFunc {
 		})
 	}
 }
+
+// GetAppUsage 获取应用的上报配额、当天/当月用量、突增保护状态和每日用量
+func GetAppUsage(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+		if days < 1 || days > 365 {
+			days = 30
+		}
+
+		report, err := st.AppUsage(c.Param("appId"), days)
+		if err != nil {
+			respondAdminError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanxi/tracely/internal/middleware"
+	"github.com/hanxi/tracely/internal/model"
+	"github.com/hanxi/tracely/internal/store"
+	"gorm.io/gorm"
+)
+
+// recordAudit 记录审计日志，username 为操作人（登录接口中上下文还没有用户名）
+func recordAudit(c *gin.Context, st *store.Store, username, action, target string, success bool, details gin.H) {
+	if middleware.IsAPITokenRequest(c) {
+		if details == nil {
+			details = gin.H{}
+		}
+		details["apiTokenId"] = c.GetUint("apiTokenId")
+	}
+	st.RecordAudit(model.AuditLog{
+		Username:  username,
+		Action:    action,
+		Target:    target,
+		Success:   success,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Details:   details,
+	})
+}
+
+// audit 记录当前用户成功执行的操作
+func audit(c *gin.Context, st *store.Store, action, target string, details gin.H) {
+	recordAudit(c, st, c.GetString("username"), action, target, true, details)
+}
+
+// AuditDataRead 记录查看原始上报数据的请求（用户时间线、事件明细、错误列表），只记录成功的请求
+// 操作对象为路径参数 :userId，没有时为 appID 参数
+func AuditDataRead(st *store.Store, action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if c.Writer.Status() != http.StatusOK {
+			return
+		}
+		target := c.Param("userId")
+		if target == "" {
+			target = c.Query("appID")
+		}
+		audit(c, st, action, target, gin.H{"query": c.Request.URL.RawQuery})
+	}
+}
+
+// ListAuditLogs 查询审计日志（仅 admin）
+// 支持按 username、action（以 . 结尾时按前缀，如 app.）、target、success 和时间区间 since/until（RFC3339）筛选
+func ListAuditLogs(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "50"))
+		if page < 1 {
+			page = 1
+		}
+		if pageSize < 1 || pageSize > 200 {
+			pageSize = 50
+		}
+
+		filter := model.AuditFilter{
+			Username: c.Query("username"),
+			Action:   c.Query("action"),
+			Target:   c.Query("target"),
+		}
+		if s := c.Query("success"); s != "" {
+			success, err := strconv.ParseBool(s)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "success 参数错误"})
+				return
+			}
+			filter.Success = &success
+		}
+		for param, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			if s := c.Query(param); s != "" {
+				t, err := time.Parse(time.RFC3339, s)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": param + " 参数错误"})
+					return
+				}
+				*dst = t.Local() // 与数据库中的时间格式（本地时区）保持一致
+			}
+		}
+
+		logs, total, err := model.ListAuditLogs(db, filter, (page-1)*pageSize, pageSize)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"total": total,
+			"list":  logs,
+		})
+	}
+}
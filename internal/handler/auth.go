@@ -1,11 +1,18 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hanxi/tracely/internal/config"
 	"github.com/hanxi/tracely/internal/middleware"
+	"github.com/hanxi/tracely/internal/model"
+	"github.com/hanxi/tracely/internal/store"
 )
 
 // LoginRequest 登录请求
@@ -14,39 +21,178 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
-// Login 登录接口
-func Login(cfg *config.Config) gin.HandlerFunc {
+// RefreshRequest 刷新 Token 请求
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// accessExpiresAt 计算 Access Token 过期时间（不超过会话过期时间）
+func accessExpiresAt(cfg *config.Config, sessionExpiresAt time.Time) time.Time {
+	expiresAt := time.Now().Add(time.Duration(cfg.JWT.AccessTokenMinutes) * time.Minute)
+	if expiresAt.After(sessionExpiresAt) {
+		return sessionExpiresAt
+	}
+	return expiresAt
+}
+
+// respondTokens 签发 Access Token 并返回登录结果，extra 为附加的返回字段
+func respondTokens(c *gin.Context, cfg *config.Config, user model.User, session *model.Session, refreshToken string, extra gin.H) {
+	token, err := middleware.GenerateToken(cfg.JWT.Secret, user.Username, user.GetRole(), user.AllowedApps(),
+		session.SessionID, session.AccessJTI, session.AccessExpiresAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成 Token 失败"})
+		return
+	}
+
+	resp := gin.H{
+		"token":        token,
+		"refreshToken": refreshToken,
+		"expiresIn":    int(time.Until(session.AccessExpiresAt).Seconds()),
+		"username":     user.Username,
+		"role":         user.GetRole(),
+	}
+	for k, v := range extra {
+		resp[k] = v
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// startSession 为已认证的用户创建会话，返回 Access Token 和 Refresh Token
+func startSession(c *gin.Context, cfg *config.Config, st *store.Store, user model.User, extra gin.H) {
+	jti, err := store.NewSessionID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成 Token 失败"})
+		return
+	}
+	expiresAt := time.Now().Add(time.Duration(cfg.JWT.ExpireHours) * time.Hour)
+	session, refreshToken, err := st.CreateSession(store.SessionInput{
+		Username:        user.Username,
+		UserAgent:       c.Request.UserAgent(),
+		IP:              c.ClientIP(),
+		ExpiresAt:       expiresAt,
+		AccessJTI:       jti,
+		AccessExpiresAt: accessExpiresAt(cfg, expiresAt),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建会话失败"})
+		return
+	}
+
+	respondTokens(c, cfg, user, session, refreshToken, extra)
+}
+
+// respondLoginBlocked 连续失败次数过多，返回 429 和需等待的秒数
+func respondLoginBlocked(c *gin.Context, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":      fmt.Sprintf("登录失败次数过多，请 %d 秒后重试", seconds),
+		"retryAfter": seconds,
+	})
+}
+
+// Login 登录接口，返回短期 Access Token 和可轮换的 Refresh Token
+func Login(st *store.Store, guard *middleware.LoginGuard) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		cfg := config.Current()
 		var req LoginRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
 			return
 		}
+		if cfg.DisablePasswordLogin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "已禁用密码登录，请使用单点登录"})
+			return
+		}
+
+		// 连续失败过多：退避或锁定期间直接拒绝
+		ip := c.ClientIP()
+		if wait := guard.Check(req.Username, ip); wait > 0 {
+			respondLoginBlocked(c, wait)
+			return
+		}
 
-		// 查找用户
-		user, ok := cfg.GetUser(req.Username)
+		// 查找用户并验证密码
+		// 故意不区分"用户不存在"和"密码错误"，用户不存在时也执行一次 bcrypt，防止通过响应或耗时枚举用户名
+		user, ok := st.GetUser(req.Username)
 		if !ok {
-			// 故意不区分"用户不存在"和"密码错误"，防止用户名枚举
+			model.VerifyDummyPassword(req.Password)
+		}
+		if !ok || !user.VerifyPassword(req.Password) {
+			guard.Fail(req.Username, ip)
+			recordAudit(c, st, req.Username, model.AuditLoginFailure, req.Username, false, gin.H{"reason": "invalid_password"})
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
 			return
 		}
 
-		// 验证密码
-		if !user.VerifyPassword(req.Password) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
+		// 已启用或被要求启用两步验证：返回 MFA Token，进入第二步（通过后才清除失败记录）
+		if user.TOTPEnabled || user.TOTPRequired {
+			respondMFAChallenge(c, st, user)
 			return
 		}
 
-		// 生成 JWT Token
-		token, err := middleware.GenerateToken(cfg.JWT.Secret, user.Username, cfg.JWT.ExpireHours)
+		guard.Succeed(user.Username)
+		recordAudit(c, st, user.Username, model.AuditLoginSuccess, user.Username, true, gin.H{"method": "password"})
+		startSession(c, cfg, st, user, nil)
+	}
+}
+
+// Refresh 使用 Refresh Token 换取新的 Access Token 和 Refresh Token（旧 Refresh Token 失效）
+// 用户角色和应用权限按当前数据重新签发
+func Refresh(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := config.Current()
+		var req RefreshRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+
+		jti, err := store.NewSessionID()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "生成 Token 失败"})
 			return
 		}
-
-		c.JSON(http.StatusOK, gin.H{
-			"token":    token,
-			"username": user.Username,
+		session, refreshToken, err := st.RotateSession(req.RefreshToken, func(s *model.Session) (string, time.Time) {
+			return jti, accessExpiresAt(cfg, s.ExpiresAt)
 		})
+		if err != nil {
+			if errors.Is(err, store.ErrInvalidToken) || errors.Is(err, store.ErrTokenReused) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "刷新 Token 失败"})
+			}
+			return
+		}
+
+		user, ok := st.GetUser(session.Username)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": store.ErrInvalidToken.Error()})
+			return
+		}
+
+		respondTokens(c, cfg, user, session, refreshToken, nil)
+	}
+}
+
+// Logout 退出登录：吊销当前会话和 Access Token（需在 JWTAuth 之后使用）
+func Logout(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if middleware.IsAPITokenRequest(c) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "API Token 请通过 /api/tokens 吊销"})
+			return
+		}
+
+		if err := st.RevokeSession(c.GetString("sessionId")); err != nil && !errors.Is(err, store.ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "退出登录失败"})
+			return
+		}
+		if err := st.RevokeAccessToken(c.GetString("jti"), c.GetTime("tokenExpiresAt")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "退出登录失败"})
+			return
+		}
+
+		audit(c, st, model.AuditLogout, c.GetString("username"), nil)
+		c.JSON(http.StatusOK, gin.H{"message": "已退出登录"})
 	}
 }
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanxi/tracely/internal/model"
+)
+
+// Delta 与上一周期相比的变化
+type Delta struct {
+	Abs int64    `json:"abs"` // 绝对变化
+	Pct *float64 `json:"pct"` // 百分比变化（上期为 0 时为 null）
+}
+
+// newDelta 计算当前值相对上期值的变化
+func newDelta(current, previous int64) Delta {
+	d := Delta{Abs: current - previous}
+	if previous != 0 {
+		pct := math.Round(float64(current-previous)/float64(previous)*10000) / 100
+		d.Pct = &pct
+	}
+	return d
+}
+
+// wantCompare 是否请求环比（compare=true 或 compare=1）
+func wantCompare(c *gin.Context) bool {
+	v := c.Query("compare")
+	return v == "true" || v == "1"
+}
+
+// previousDate 将 UTC 日期字符串平移 days 天，用于对齐上一周期的日期
+func previousDate(date string, days int) string {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return ""
+	}
+	return t.AddDate(0, 0, -days).Format("2006-01-02")
+}
+
+// EventStatsComparison 单个事件的环比
+type EventStatsComparison struct {
+	EventName     string `json:"eventName"`
+	Count         int64  `json:"count"`
+	PreviousCount int64  `json:"previousCount"`
+	Delta         Delta  `json:"delta"`
+}
+
+// compareEventStats 按事件名称对齐本期与上期统计（保持本期排序，上期独有的事件排在最后）
+func compareEventStats(current, previous []model.EventStats) []EventStatsComparison {
+	prevCount := make(map[string]int64, len(previous))
+	for _, s := range previous {
+		prevCount[s.EventName] = s.Count
+	}
+
+	result := make([]EventStatsComparison, 0, len(current)+len(previous))
+	seen := make(map[string]bool, len(current))
+	for _, s := range current {
+		seen[s.EventName] = true
+		result = append(result, EventStatsComparison{
+			EventName:     s.EventName,
+			Count:         s.Count,
+			PreviousCount: prevCount[s.EventName],
+			Delta:         newDelta(s.Count, prevCount[s.EventName]),
+		})
+	}
+	for _, s := range previous {
+		if !seen[s.EventName] {
+			result = append(result, EventStatsComparison{
+				EventName:     s.EventName,
+				PreviousCount: s.Count,
+				Delta:         newDelta(0, s.Count),
+			})
+		}
+	}
+	return result
+}
+
+// DailyComparison 单日环比
+type DailyComparison struct {
+	Date          string `json:"date"`
+	Count         int64  `json:"count"`
+	PreviousDate  string `json:"previousDate"`
+	PreviousCount int64  `json:"previousCount"`
+	Delta         Delta  `json:"delta"`
+}
+
+// compareDaily 将上期每日数据平移 days 天后与本期按日期对齐
+func compareDaily(current, previous []model.DailyEvent, days int) []DailyComparison {
+	curCount := make(map[string]int64, len(current))
+	for _, d := range current {
+		curCount[d.Date] = d.Count
+	}
+	prevCount := make(map[string]int64, len(previous))
+	dates := make([]string, 0, len(current)+len(previous))
+	for _, d := range current {
+		dates = append(dates, d.Date)
+	}
+	for _, d := range previous {
+		prevCount[d.Date] = d.Count
+		if date := previousDate(d.Date, -days); date != "" {
+			if _, ok := curCount[date]; !ok {
+				dates = append(dates, date)
+			}
+		}
+	}
+	sort.Strings(dates)
+
+	result := make([]DailyComparison, 0, len(dates))
+	for _, date := range dates {
+		prev := previousDate(date, days)
+		result = append(result, DailyComparison{
+			Date:          date,
+			Count:         curCount[date],
+			PreviousDate:  prev,
+			PreviousCount: prevCount[prev],
+			Delta:         newDelta(curCount[date], prevCount[prev]),
+		})
+	}
+	return result
+}
+
+// sumDaily 汇总每日数据
+func sumDaily(daily []model.DailyEvent) int64 {
+	var total int64
+	for _, d := range daily {
+		total += d.Count
+	}
+	return total
+}
@@ -1,11 +1,16 @@
 package handler
 
 import (
+	"math"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/hanxi/tracely/internal/ingest"
+	"github.com/hanxi/tracely/internal/metrics"
+	"github.com/hanxi/tracely/internal/middleware"
 	"github.com/hanxi/tracely/internal/model"
+	"github.com/hanxi/tracely/internal/store"
 	"gorm.io/gorm"
 )
 
@@ -16,57 +21,88 @@ type ErrorRequest struct {
 	Stack   string `json:"stack"`
 	URL     string `json:"url"`
 	AppID   string `json:"appId" binding:"required"`
+	UserID  string `json:"userId"` // 可选，用于用户时间线
 }
 
-// ReportError 上报错误接口
-func ReportError(db *gorm.DB) gin.HandlerFunc {
+// queueRetryAfterSeconds 写入队列已满时建议 SDK 等待的秒数
+const queueRetryAfterSeconds = "1"
+
+// ReportError 上报错误接口（校验通过后进入写入队列即返回）
+func ReportError(q *ingest.Queue, st *store.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req ErrorRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+			middleware.RejectReport(c, http.StatusBadRequest, "请求参数错误", metrics.ReasonInvalidBody)
+			return
+		}
+
+		appID, ok := reportAppID(c, req.AppID)
+		if !ok {
+			return
+		}
+		if !admitReport(c, st, appID, model.UsageKindError) {
 			return
 		}
 
-		// 生成错误指纹
-		fingerprint := model.GenFingerprint(req.AppID, req.Type, req.Message)
-
-		// 查询是否存在相同指纹
-		var existing model.ErrorLog
-		if err := db.Where("fingerprint = ?", fingerprint).First(&existing).Error; err == nil {
-			// 存在则更新
-			existing.Count++
-			existing.LastSeen = model.GetDB().NowFunc()
-			existing.Stack = req.Stack
-			existing.URL = req.URL
-			if err := db.Save(&existing).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库操作失败"})
-				return
-			}
-		} else {
-			// 不存在则插入
-			now := model.GetDB().NowFunc()
-			newLog := model.ErrorLog{
-				Fingerprint: fingerprint,
-				Type:        req.Type,
-				Message:     req.Message,
-				Stack:       req.Stack,
-				URL:         req.URL,
-				AppID:       req.AppID,
-				UserAgent:   c.GetHeader("User-Agent"),
-				Count:       1,
-				FirstSeen:   now,
-				LastSeen:    now,
-			}
-			if err := db.Create(&newLog).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库操作失败"})
-				return
-			}
+		// 写入前脱敏（消息脱敏后再计算指纹，同类错误不会因邮箱等不同而分散）
+		scrubber := st.Scrubber(appID)
+		err := q.EnqueueError(model.ErrorReport{
+			Type:       req.Type,
+			Message:    scrubber.String(req.Message),
+			Stack:      scrubber.String(req.Stack),
+			URL:        scrubber.URL(req.URL),
+			AppID:      req.AppID,
+			UserID:     req.UserID,
+			UserAgent:  c.GetHeader("User-Agent"),
+			ReportedAt: model.GetDB().NowFunc(),
+		})
+		if err != nil {
+			st.CancelAdmit(appID, model.UsageKindError)
+			rejectQueueFull(c)
+			return
 		}
 
+		metrics.ReportsAccepted.Inc(appID, metrics.ReportError)
 		c.JSON(http.StatusOK, gin.H{"message": "上报成功"})
 	}
 }
 
+// reportAppID 返回已通过签名验证的 X-App-Id，请求体中的 appId 必须与其一致，
+// 否则配额、脱敏规则和指标按签名应用计算，数据却写入另一个应用
+func reportAppID(c *gin.Context, bodyAppID string) (string, bool) {
+	appID := c.GetHeader("X-App-Id")
+	if bodyAppID != appID {
+		middleware.RejectReport(c, http.StatusBadRequest, "appId 与 X-App-Id 不一致", metrics.ReasonInvalidBody)
+		return "", false
+	}
+	return appID, true
+}
+
+// admitReport 按应用配额和突增保护检查上报（X-App-Id 已通过签名验证）
+// 超出配额返回 429 + Retry-After（距离配额重置的秒数）；
+// 突增保护丢弃的上报返回 200，避免 SDK 重试加重突增
+func admitReport(c *gin.Context, st *store.Store, appID, kind string) bool {
+	admission := st.Admit(appID, kind)
+	switch {
+	case admission.Allowed:
+		return true
+	case admission.Reason == store.DropSpike:
+		metrics.ReportsRejected.Inc(metrics.ReasonSpikeSampled)
+		c.JSON(http.StatusOK, gin.H{"message": "上报量突增，已采样丢弃", "dropped": true})
+		c.Abort()
+	default:
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(admission.RetryAfter.Seconds()))))
+		middleware.RejectReport(c, http.StatusTooManyRequests, "超出应用上报配额", metrics.ReasonQuotaExceeded)
+	}
+	return false
+}
+
+// rejectQueueFull 写入队列已满（或服务正在关闭）时返回 503，SDK 按 Retry-After 稍后重试
+func rejectQueueFull(c *gin.Context) {
+	c.Header("Retry-After", queueRetryAfterSeconds)
+	middleware.RejectReport(c, http.StatusServiceUnavailable, "服务繁忙，请稍后重试", metrics.ReasonQueueFull)
+}
+
 // ErrorList 获取错误列表接口
 func ErrorList(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -3,11 +3,15 @@ package handler
 import (
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/hanxi/tracely/internal/config"
+	"github.com/hanxi/tracely/internal/ingest"
+	"github.com/hanxi/tracely/internal/metrics"
+	"github.com/hanxi/tracely/internal/middleware"
 	"github.com/hanxi/tracely/internal/model"
+	"github.com/hanxi/tracely/internal/store"
 	"gorm.io/gorm"
 )
 
@@ -19,32 +23,77 @@ type EventRequest struct {
 	UserID    string                 `json:"userId" binding:"required"`
 }
 
-// ReportEvent 上报事件接口
-func ReportEvent(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+// ReportEvent 上报事件接口（校验通过后进入写入队列即返回）
+func ReportEvent(q *ingest.Queue, st *store.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req EventRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+			middleware.RejectReport(c, http.StatusBadRequest, "请求参数错误", metrics.ReasonInvalidBody)
 			return
 		}
 
-		// 验证事件是否在白名单中
-		if !cfg.IsEventAllowed(req.EventName) {
-			c.JSON(http.StatusForbidden, gin.H{"error": "事件未在白名单中"})
+		appID, ok := reportAppID(c, req.AppID)
+		if !ok {
 			return
 		}
 
-		// 创建事件记录
-		if err := model.CreateEvent(db, req.EventName, req.Metadata, req.AppID, req.UserID); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "数据库操作失败"})
+		// 验证事件是否在白名单中（开启自动发现时记录到待审核列表，不保存事件数据）
+		def, ok := st.GetEvent(req.EventName)
+		if !ok {
+			if st.DiscoverEvent(req.EventName, appID) {
+				metrics.ReportsRejected.Inc(metrics.ReasonEventPending)
+				c.JSON(http.StatusAccepted, gin.H{"message": "事件待审核，加入白名单后开始接收", "pending": true})
+				return
+			}
+			middleware.RejectReport(c, http.StatusForbidden, "事件未在白名单中", metrics.ReasonEventNotAllowed)
 			return
 		}
 
+		// 按事件定义的 schema 校验 metadata：reject 拒绝，strip 去掉不合规的属性，warn 原样保存
+		var warnings []string
+		if def.Schema != nil {
+			problems, stripped := def.Schema.Validate(req.Metadata)
+			if len(problems) > 0 {
+				mode := def.Schema.GetMode()
+				st.RecordValidationFailure(def.EventName, strings.Join(problems, "; "))
+				metrics.EventValidationFailures.Inc(def.EventName, mode)
+				switch mode {
+				case model.SchemaModeReject:
+					middleware.RejectReport(c, http.StatusBadRequest, "metadata 校验失败: "+strings.Join(problems, "; "), metrics.ReasonInvalidMetadata)
+					return
+				case model.SchemaModeStrip:
+					req.Metadata = stripped
+				}
+				warnings = problems
+			}
+		}
+
+		// 创建事件记录（metadata 写入前脱敏）
+		event, err := model.NewEvent(req.EventName, st.Scrubber(appID).Metadata(req.Metadata), req.AppID, req.UserID)
+		if err != nil {
+			middleware.RejectReport(c, http.StatusBadRequest, "请求参数错误", metrics.ReasonInvalidBody)
+			return
+		}
+
+		if !admitReport(c, st, appID, model.UsageKindEvent) {
+			return
+		}
+		if err := q.EnqueueEvent(event); err != nil {
+			st.CancelAdmit(appID, model.UsageKindEvent)
+			rejectQueueFull(c)
+			return
+		}
+
+		metrics.ReportsAccepted.Inc(appID, metrics.ReportEvent)
+		if len(warnings) > 0 {
+			c.JSON(http.StatusOK, gin.H{"message": "上报成功", "warnings": warnings})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{"message": "上报成功"})
 	}
 }
 
-// GetEventStats 获取事件统计接口
+// GetEventStats 获取事件统计接口（compare=true 时附带上一周期对比）
 func GetEventStats(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		days, _ := strconv.Atoi(c.DefaultQuery("days", "7"))
@@ -55,18 +104,31 @@ func GetEventStats(db *gorm.DB) gin.HandlerFunc {
 			days = 7
 		}
 
-		stats, err := model.GetEventStats(db, appID, eventName, days)
+		now := time.Now()
+		from := now.AddDate(0, 0, -days)
+
+		stats, err := model.GetEventStatsBetween(db, appID, eventName, from, now)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
 			return
 		}
 
-		// 确保返回空数组而不是 null
-		if stats == nil {
-			stats = []model.EventStats{}
+		if !wantCompare(c) {
+			c.JSON(http.StatusOK, gin.H{"stats": stats})
+			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"stats": stats})
+		previous, err := model.GetEventStatsBetween(db, appID, eventName, from.AddDate(0, 0, -days), from)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"stats":      stats,
+			"previous":   previous,
+			"comparison": compareEventStats(stats, previous),
+		})
 	}
 }
 
@@ -99,7 +161,7 @@ func GetTopEvents(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
-// GetDailyEvents 获取每日事件统计接口
+// GetDailyEvents 获取每日事件统计接口（compare=true 时附带上一周期对比）
 func GetDailyEvents(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		days, _ := strconv.Atoi(c.DefaultQuery("days", "7"))
@@ -110,18 +172,32 @@ func GetDailyEvents(db *gorm.DB) gin.HandlerFunc {
 			days = 7
 		}
 
-		daily, err := model.GetDailyEvents(db, appID, eventName, days)
+		now := time.Now()
+		from := now.AddDate(0, 0, -days)
+
+		daily, err := model.GetDailyEventsBetween(db, appID, eventName, from, now)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
 			return
 		}
 
-		// 确保返回空数组而不是 null
-		if daily == nil {
-			daily = []model.DailyEvent{}
+		if !wantCompare(c) {
+			c.JSON(http.StatusOK, gin.H{"daily": daily})
+			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"daily": daily})
+		previous, err := model.GetDailyEventsBetween(db, appID, eventName, from.AddDate(0, 0, -days), from)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"daily":      daily,
+			"previous":   previous,
+			"comparison": compareDaily(daily, previous, days),
+			"delta":      newDelta(sumDaily(daily), sumDaily(previous)),
+		})
 	}
 }
 
@@ -206,7 +282,14 @@ func GetEventList(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
-// GetEventStatsSummary 获取事件统计摘要接口
+// summaryComparison 带环比的事件统计摘要（本期字段保持平铺，兼容旧响应）
+type summaryComparison struct {
+	*model.EventStatsSummary
+	Previous *model.EventStatsSummary `json:"previous"`
+	Delta    map[string]Delta         `json:"delta"`
+}
+
+// GetEventStatsSummary 获取事件统计摘要接口（compare=true 时附带上一周期对比）
 func GetEventStatsSummary(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		appID := c.Query("appID")
@@ -217,12 +300,37 @@ func GetEventStatsSummary(db *gorm.DB) gin.HandlerFunc {
 			days = 7
 		}
 
-		summary, err := model.GetEventStatsSummary(db, appID, eventName, days)
+		now := time.Now()
+		from := now.AddDate(0, 0, -days)
+		today := now.Truncate(24 * time.Hour)
+
+		summary, err := model.GetEventStatsSummaryBetween(db, appID, eventName, from, today, now)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
 			return
 		}
 
-		c.JSON(http.StatusOK, summary)
+		if !wantCompare(c) {
+			c.JSON(http.StatusOK, summary)
+			return
+		}
+
+		// 上一周期：整体前移 days 天，"今日"对应上一周期的同一天同一时刻
+		shift := func(t time.Time) time.Time { return t.AddDate(0, 0, -days) }
+		previous, err := model.GetEventStatsSummaryBetween(db, appID, eventName, shift(from), shift(today), shift(now))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			return
+		}
+
+		c.JSON(http.StatusOK, summaryComparison{
+			EventStatsSummary: summary,
+			Previous:          previous,
+			Delta: map[string]Delta{
+				"totalCount": newDelta(summary.TotalCount, previous.TotalCount),
+				"todayCount": newDelta(summary.TodayCount, previous.TodayCount),
+				"uv":         newDelta(summary.UV, previous.UV),
+			},
+		})
 	}
 }
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanxi/tracely/internal/health"
+	"github.com/hanxi/tracely/internal/model"
+	"github.com/hanxi/tracely/internal/version"
+	"gorm.io/gorm"
+)
+
+// readyPingTimeout /readyz 数据库 Ping 超时时间
+const readyPingTimeout = 2 * time.Second
+
+// 健康检查状态
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+)
+
+// CheckResult 单项就绪检查结果
+type CheckResult struct {
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latencyMs"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Healthz 存活检查：进程能处理请求即返回 200
+func Healthz() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"status":  statusOK,
+			"version": version.Version,
+		})
+	}
+}
+
+// Readyz 就绪检查：数据库可连通、数据表已迁移、后台任务在运行，任一项失败返回 503
+func Readyz(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		checks := map[string]CheckResult{
+			"database":   checkDatabase(c.Request.Context(), db),
+			"migrations": checkMigrations(db),
+		}
+
+		workers := health.Workers()
+		workerCheck := CheckResult{Status: statusOK}
+		var stopped []string
+		for _, w := range workers {
+			if !w.Healthy {
+				stopped = append(stopped, w.Name)
+			}
+		}
+		if len(stopped) > 0 {
+			workerCheck.Status = statusFail
+			workerCheck.Error = "后台任务未运行: " + strings.Join(stopped, ", ")
+		}
+		checks["workers"] = workerCheck
+
+		status, code := statusOK, http.StatusOK
+		for _, check := range checks {
+			if check.Status != statusOK {
+				status, code = statusFail, http.StatusServiceUnavailable
+				break
+			}
+		}
+
+		c.JSON(code, gin.H{
+			"status":  status,
+			"version": version.Version,
+			"checks":  checks,
+			"workers": workers,
+		})
+	}
+}
+
+// checkDatabase 在超时时间内 Ping 数据库
+func checkDatabase(ctx context.Context, db *gorm.DB) CheckResult {
+	start := time.Now()
+	sqlDB, err := db.DB()
+	if err == nil {
+		ctx, cancel := context.WithTimeout(ctx, readyPingTimeout)
+		defer cancel()
+		err = sqlDB.PingContext(ctx)
+	}
+
+	result := CheckResult{Status: statusOK, LatencyMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Status = statusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// checkMigrations 检查所有数据表已创建
+func checkMigrations(db *gorm.DB) CheckResult {
+	start := time.Now()
+	missing, err := model.CheckMigrations(db)
+
+	result := CheckResult{Status: statusOK, LatencyMs: time.Since(start).Milliseconds()}
+	switch {
+	case err != nil:
+		result.Status = statusFail
+		result.Error = err.Error()
+	case len(missing) > 0:
+		result.Status = statusFail
+		result.Error = "缺少数据表: " + strings.Join(missing, ", ")
+	}
+	return result
+}
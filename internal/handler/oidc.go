@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanxi/tracely/internal/config"
+	"github.com/hanxi/tracely/internal/model"
+	"github.com/hanxi/tracely/internal/oidc"
+	"github.com/hanxi/tracely/internal/store"
+)
+
+// OIDCTokenRequest 兑换一次性登录码请求
+type OIDCTokenRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// AuthConfig 登录方式配置（登录页据此显示密码登录和 / 或单点登录）
+func AuthConfig() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := config.Current()
+		c.JSON(http.StatusOK, gin.H{
+			"passwordLogin": !cfg.DisablePasswordLogin,
+			"oidc":          cfg.OIDC.Enabled,
+		})
+	}
+}
+
+// redirectLoginPage 跳转回 Dashboard 登录页（Hash 路由）
+func redirectLoginPage(c *gin.Context, params url.Values) {
+	c.Redirect(http.StatusFound, "/#/login?"+params.Encode())
+}
+
+// oidcStateCookie 保存登录请求 state 的 Cookie，回调时与 URL 中的 state 比对，
+// 确保回调来自发起登录的同一浏览器（防止攻击者诱导受害者登录攻击者的账号）
+const oidcStateCookie = "tracely_oidc_state"
+
+// setOIDCStateCookie 写入（value 为空时清除）state Cookie，仅在 /auth/oidc 下发送
+// IdP 回调是跨站的顶层跳转，SameSite 须为 Lax
+func setOIDCStateCookie(c *gin.Context, value string) {
+	maxAge := int(oidc.AuthRequestTTL / time.Second)
+	if value == "" {
+		maxAge = -1
+	}
+	secure := strings.HasPrefix(config.Current().OIDC.RedirectURL, "https://")
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, value, maxAge, "/auth/oidc", "", secure, true)
+}
+
+// OIDCLogin 跳转到 IdP 登录（授权码 + PKCE）
+func OIDCLogin(p *oidc.Provider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := oidc.NewAuthRequest()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "生成登录请求失败"})
+			return
+		}
+
+		authURL, err := p.AuthCodeURL(c.Request.Context(), req)
+		if err != nil {
+			fmt.Printf("[Tracely] OIDC login failed: %v\n", err)
+			redirectLoginPage(c, url.Values{"error": {"无法连接单点登录服务"}})
+			return
+		}
+
+		p.SaveAuthRequest(req)
+		setOIDCStateCookie(c, req.State)
+		c.Redirect(http.StatusFound, authURL)
+	}
+}
+
+// OIDCCallback IdP 登录回调：校验 ID Token，同步用户，然后带一次性登录码跳转回 Dashboard
+func OIDCCallback(st *store.Store, p *oidc.Provider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := config.Current()
+		state, _ := c.Cookie(oidcStateCookie)
+		setOIDCStateCookie(c, "")
+		if msg := c.Query("error"); msg != "" {
+			if desc := c.Query("error_description"); desc != "" {
+				msg = desc
+			}
+			redirectLoginPage(c, url.Values{"error": {msg}})
+			return
+		}
+
+		if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+			redirectLoginPage(c, url.Values{"error": {"登录请求无效，请重新登录"}})
+			return
+		}
+		req, ok := p.TakeAuthRequest(state)
+		if !ok {
+			redirectLoginPage(c, url.Values{"error": {"登录请求已过期，请重试"}})
+			return
+		}
+
+		claims, err := p.Exchange(c.Request.Context(), c.Query("code"), req)
+		if err != nil {
+			fmt.Printf("[Tracely] OIDC callback failed: %v\n", err)
+			redirectLoginPage(c, url.Values{"error": {"单点登录验证失败"}})
+			return
+		}
+
+		identity, err := p.Identity(claims)
+		if err != nil {
+			msg := "单点登录验证失败"
+			if errors.Is(err, oidc.ErrNotAuthorized) {
+				msg = err.Error()
+			}
+			fmt.Printf("[Tracely] OIDC identity rejected: %v\n", err)
+			username, _ := claims[cfg.OIDC.UsernameClaim].(string)
+			recordAudit(c, st, username, model.AuditLoginFailure, username, false, gin.H{"method": "oidc", "reason": err.Error()})
+			redirectLoginPage(c, url.Values{"error": {msg}})
+			return
+		}
+
+		user, err := st.ProvisionOIDCUser(identity, cfg.OIDC.AutoProvision)
+		if err != nil {
+			msg := "同步用户失败"
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				msg = "用户不存在，请联系管理员添加"
+				recordAudit(c, st, identity.Username, model.AuditLoginFailure, identity.Username, false, gin.H{"method": "oidc", "reason": "user_not_found", "subject": identity.Subject})
+			case errors.Is(err, store.ErrOIDCNotLinked):
+				msg = err.Error()
+				recordAudit(c, st, identity.Username, model.AuditLoginFailure, identity.Username, false, gin.H{"method": "oidc", "reason": "not_linked", "subject": identity.Subject})
+			}
+			redirectLoginPage(c, url.Values{"error": {msg}})
+			return
+		}
+
+		code, err := p.IssueLoginCode(user.Username)
+		if err != nil {
+			redirectLoginPage(c, url.Values{"error": {"生成登录码失败"}})
+			return
+		}
+		redirectLoginPage(c, url.Values{"code": {code}})
+	}
+}
+
+// OIDCToken 兑换一次性登录码，返回 Access Token 和 Refresh Token
+// 启用或被要求启用两步验证的用户与密码登录一样，需再提交验证码
+func OIDCToken(st *store.Store, p *oidc.Provider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := config.Current()
+		var req OIDCTokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+
+		username, ok := p.RedeemLoginCode(req.Code)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "登录码无效或已过期"})
+			return
+		}
+		user, ok := st.GetUser(username)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
+			return
+		}
+
+		if user.TOTPEnabled || user.TOTPRequired {
+			respondMFAChallenge(c, st, user)
+			return
+		}
+		recordAudit(c, st, user.Username, model.AuditLoginSuccess, user.Username, true, gin.H{"method": "oidc"})
+		startSession(c, cfg, st, user, nil)
+	}
+}
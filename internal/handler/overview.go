@@ -23,6 +23,17 @@ type DashboardOverview struct {
 	TodayErrors int64        `json:"todayErrors"` // 今日新增错误
 	TopErrors   []TopError   `json:"topErrors"`   // Top 5 错误
 	ErrorTrend  []ErrorTrend `json:"errorTrend"`  // 近 7 日错误趋势
+
+	Compare *OverviewComparison `json:"compare,omitempty"` // 环比（compare=true 时返回）
+}
+
+// OverviewComparison 概览环比：今日对比昨日同一时刻，近 7 日对比之前 7 日
+type OverviewComparison struct {
+	PreviousPV     int64            `json:"previousPV"`     // 昨日同时段 PV
+	PreviousUV     int64            `json:"previousUV"`     // 昨日同时段 UV
+	PreviousErrors int64            `json:"previousErrors"` // 昨日同时段新增错误
+	PreviousTrend  []ErrorTrend     `json:"previousTrend"`  // 之前 7 日错误趋势
+	Delta          map[string]Delta `json:"delta"`
 }
 
 // TopError 顶部错误
@@ -37,55 +48,106 @@ func Overview(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		appID := c.Query("appID")
 
-		// 构建基础查询
-		errorQuery := db.Model(&model.ErrorLog{})
-
-		if appID != "" {
-			errorQuery = errorQuery.Where("app_id = ?", appID)
+		// 构建基础查询（每次返回新的查询，避免链式条件互相累加）
+		errorQuery := func() *gorm.DB {
+			query := db.Model(&model.ErrorLog{})
+			if appID != "" {
+				query = query.Where("app_id = ?", appID)
+			}
+			return query
 		}
 
 		// 计算今日 0 点时间
 		now := time.Now()
 		todayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		weekStart := todayStart.AddDate(0, 0, -6)
 
-		// 今日 PV/UV（使用事件模型，查询 _active 事件）
+		// 今日 PV/UV（使用事件模型，查询 _active 事件，已关闭的小时读取汇总表）
 		var todayPV, todayUV int64
 		todayPV, _ = model.GetEventCount(db, appID, model.EVENT_ACTIVE, todayStart)
 		todayUV, _ = model.GetUniqueUserCount(db, appID, model.EVENT_ACTIVE, todayStart)
 
-		// 错误统计
-		var totalErrors, todayErrors int64
-		errorQuery.Count(&totalErrors)
-		errorQuery.Where("first_seen >= ?", todayStart).Count(&todayErrors)
+		// 错误总数
+		var totalErrors int64
+		errorQuery().Count(&totalErrors)
 
 		// Top 5 错误 - 按指纹分组统计
 		var topErrors []TopError
-		errorQuery.Select("type, message, SUM(count) as count").
+		errorQuery().Select("type, message, SUM(count) as count").
 			Group("fingerprint, type, message").
 			Order("count DESC").
 			Limit(5).
 			Find(&topErrors)
 
-		// 近 7 日错误趋势
-		var errorTrend []ErrorTrend
-		for i := 6; i >= 0; i-- {
-			dayStart := time.Date(now.Year(), now.Month(), now.Day()-i, 0, 0, 0, 0, now.Location())
-			dayEnd := dayStart.Add(24 * time.Hour)
-			var count int64
-			errorQuery.Where("first_seen >= ? AND first_seen < ?", dayStart, dayEnd).Count(&count)
-			errorTrend = append(errorTrend, ErrorTrend{
-				Date:  dayStart.Format("01/02"),
-				Count: int(count),
-			})
+		// 近 7 日新增错误：一次查询取出首次出现时间，按本地日期归集
+		// 需要环比时多取之前 7 日
+		compare := wantCompare(c)
+		trendStart := weekStart
+		if compare {
+			trendStart = weekStart.AddDate(0, 0, -7)
+		}
+
+		var firstSeen []time.Time
+		errorQuery().Where("first_seen >= ?", trendStart).Pluck("first_seen", &firstSeen)
+
+		// 昨日同时段：[昨日 0 点, 24 小时前)
+		yesterdayStart := todayStart.AddDate(0, 0, -1)
+		yesterdayNow := now.AddDate(0, 0, -1)
+
+		var todayErrors, yesterdayErrors int64
+		countByDay := make(map[string]int)
+		for _, t := range firstSeen {
+			countByDay[t.In(now.Location()).Format("01/02")]++
+			if !t.Before(todayStart) {
+				todayErrors++
+			} else if !t.Before(yesterdayStart) && t.Before(yesterdayNow) {
+				yesterdayErrors++
+			}
 		}
 
-		c.JSON(http.StatusOK, DashboardOverview{
+		trend := func(start time.Time) ([]ErrorTrend, int64) {
+			result := make([]ErrorTrend, 0, 7)
+			var total int64
+			for day := start; day.Before(start.AddDate(0, 0, 7)); day = day.AddDate(0, 0, 1) {
+				date := day.Format("01/02")
+				result = append(result, ErrorTrend{
+					Date:  date,
+					Count: countByDay[date],
+				})
+				total += int64(countByDay[date])
+			}
+			return result, total
+		}
+		errorTrend, weekErrors := trend(weekStart)
+
+		overview := DashboardOverview{
 			TodayPV:     todayPV,
 			TodayUV:     todayUV,
 			TotalErrors: totalErrors,
 			TodayErrors: todayErrors,
 			TopErrors:   topErrors,
 			ErrorTrend:  errorTrend,
-		})
+		}
+
+		if compare {
+			previousPV, _ := model.CountEventsBetween(db, appID, model.EVENT_ACTIVE, yesterdayStart, yesterdayNow)
+			previousUV, _ := model.CountUniqueUsersBetween(db, appID, model.EVENT_ACTIVE, yesterdayStart, yesterdayNow)
+			previousTrend, previousWeekErrors := trend(weekStart.AddDate(0, 0, -7))
+
+			overview.Compare = &OverviewComparison{
+				PreviousPV:     previousPV,
+				PreviousUV:     previousUV,
+				PreviousErrors: yesterdayErrors,
+				PreviousTrend:  previousTrend,
+				Delta: map[string]Delta{
+					"todayPV":     newDelta(todayPV, previousPV),
+					"todayUV":     newDelta(todayUV, previousUV),
+					"todayErrors": newDelta(todayErrors, yesterdayErrors),
+					"weekErrors":  newDelta(weekErrors, previousWeekErrors),
+				},
+			}
+		}
+
+		c.JSON(http.StatusOK, overview)
 	}
 }
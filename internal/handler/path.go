@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanxi/tracely/internal/model"
+	"gorm.io/gorm"
+)
+
+// 路径分析单次最多抽样的用户数和扫描的事件数
+const (
+	pathMaxUsers  = 10000
+	pathMaxEvents = 200000
+)
+
+// GetEventPaths 路径分析接口：起始事件之后（或之前）最常见的事件序列
+func GetEventPaths(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startEvent := c.Query("startEvent")
+		direction := c.DefaultQuery("direction", model.PathDirectionAfter)
+		depth, _ := strconv.Atoi(c.DefaultQuery("depth", "3"))
+		sessionGap, _ := strconv.Atoi(c.DefaultQuery("sessionGap", "30")) // 分钟
+		days, _ := strconv.Atoi(c.DefaultQuery("days", "7"))
+		topN, _ := strconv.Atoi(c.DefaultQuery("topN", "5"))
+
+		if startEvent == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "缺少 startEvent 参数"})
+			return
+		}
+		if direction != model.PathDirectionAfter && direction != model.PathDirectionBefore {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "direction 只能是 after 或 before"})
+			return
+		}
+		if depth < 1 || depth > 10 {
+			depth = 3
+		}
+		if sessionGap < 1 || sessionGap > 24*60 {
+			sessionGap = 30
+		}
+		if days < 1 || days > 90 {
+			days = 7
+		}
+		if topN < 1 || topN > 50 {
+			topN = 5
+		}
+
+		// 默认排除心跳事件，exclude 传空字符串可关闭
+		exclude := []string{model.EVENT_ACTIVE}
+		if v, ok := c.GetQuery("exclude"); ok {
+			exclude = nil
+			for _, name := range strings.Split(v, ",") {
+				if name = strings.TrimSpace(name); name != "" && name != startEvent {
+					exclude = append(exclude, name)
+				}
+			}
+		}
+
+		now := time.Now()
+		result, err := model.GetEventPaths(db, model.PathQuery{
+			AppID:         c.Query("appID"),
+			StartEvent:    startEvent,
+			Direction:     direction,
+			MaxDepth:      depth,
+			SessionGap:    time.Duration(sessionGap) * time.Minute,
+			From:          now.AddDate(0, 0, -days),
+			To:            now,
+			TopN:          topN,
+			ExcludeEvents: exclude,
+			MaxUsers:      pathMaxUsers,
+			MaxEvents:     pathMaxEvents,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanxi/tracely/internal/config"
+	"github.com/hanxi/tracely/internal/middleware"
+	"github.com/hanxi/tracely/internal/model"
+	"github.com/hanxi/tracely/internal/store"
+)
+
+// CreateAPITokenRequest 创建 API Token 请求
+type CreateAPITokenRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Scopes        []string `json:"scopes"`        // read / write / admin，默认 read
+	Apps          []string `json:"apps"`          // 限制可访问的应用，为空表示与用户一致
+	ExpiresInDays int      `json:"expiresInDays"` // 有效天数，0 表示永不过期
+}
+
+// rejectAPIToken API Token 不能管理 Token，避免泄露的 Token 自我续期
+func rejectAPIToken(c *gin.Context) bool {
+	if middleware.IsAPITokenRequest(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "请登录 Dashboard 后管理 API Token"})
+		return true
+	}
+	return false
+}
+
+// ListAPITokens 获取当前用户的 API Token 列表
+func ListAPITokens(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if rejectAPIToken(c) {
+			return
+		}
+
+		tokens, err := st.ListAPITokens(c.GetString("username"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+	}
+}
+
+// CreateAPIToken 创建 API Token，明文 Token 只在创建时返回一次
+func CreateAPIToken(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if rejectAPIToken(c) {
+			return
+		}
+
+		var req CreateAPITokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.ExpiresInDays < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+		if len(req.Scopes) == 0 {
+			req.Scopes = []string{model.ScopeRead}
+		}
+		for _, scope := range req.Scopes {
+			if !model.IsValidScope(scope) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "权限范围只能是 read、write 或 admin"})
+				return
+			}
+			if scope == model.ScopeAdmin && middleware.GetRole(c) != config.RoleAdmin {
+				c.JSON(http.StatusForbidden, gin.H{"error": "只有管理员可以创建 admin 权限的 Token"})
+				return
+			}
+		}
+		for _, appID := range req.Apps {
+			if !middleware.CanAccessApp(c, appID) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "无权访问该应用"})
+				return
+			}
+		}
+
+		input := store.APITokenInput{Name: req.Name, Scopes: req.Scopes, Apps: req.Apps}
+		if req.ExpiresInDays > 0 {
+			expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+			input.ExpiresAt = &expiresAt
+		}
+
+		token, raw, err := st.CreateAPIToken(c.GetString("username"), input)
+		if err != nil {
+			respondAdminError(c, err)
+			return
+		}
+
+		audit(c, st, model.AuditTokenCreate, token.Name, gin.H{"tokenId": token.ID, "scopes": token.Scopes, "apps": token.Apps})
+		c.JSON(http.StatusOK, gin.H{
+			"token":    token,
+			"apiToken": raw,
+		})
+	}
+}
+
+// RevokeAPIToken 吊销当前用户的 API Token
+func RevokeAPIToken(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if rejectAPIToken(c) {
+			return
+		}
+
+		id, err := strconv.ParseUint(c.Param("tokenId"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的 Token ID"})
+			return
+		}
+
+		token, err := st.RevokeAPIToken(c.GetString("username"), uint(id))
+		if err != nil {
+			respondAdminError(c, err)
+			return
+		}
+
+		audit(c, st, model.AuditTokenRevoke, token.Name, gin.H{"tokenId": token.ID})
+		c.JSON(http.StatusOK, gin.H{"token": token})
+	}
+}
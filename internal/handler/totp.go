@@ -0,0 +1,273 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanxi/tracely/internal/config"
+	"github.com/hanxi/tracely/internal/middleware"
+	"github.com/hanxi/tracely/internal/model"
+	"github.com/hanxi/tracely/internal/store"
+	"github.com/hanxi/tracely/internal/totp"
+)
+
+// totpIssuer 认证器 App 中显示的发行方
+const totpIssuer = "Tracely"
+
+// MFARequest 两步验证登录请求
+type MFARequest struct {
+	MFAToken string `json:"mfaToken" binding:"required"`
+	Code     string `json:"code"` // 验证码或恢复码
+}
+
+// TOTPCodeRequest 需要验证码确认的账号操作请求
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// respondTOTPError 将两步验证的业务错误转换为 HTTP 响应
+func respondTOTPError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, store.ErrInvalidCode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, store.ErrTOTPNotEnrolled), errors.Is(err, store.ErrTOTPRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, store.ErrConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": "已启用两步验证"})
+	default:
+		respondAdminError(c, err)
+	}
+}
+
+// respondMFAChallenge 密码验证通过后返回 MFA Token，要求完成两步验证（或先绑定）
+func respondMFAChallenge(c *gin.Context, st *store.Store, user model.User) {
+	token, err := st.IssueMFAToken(user.Username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成 Token 失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"mfaRequired":   true,
+		"setupRequired": !user.TOTPEnabled,
+		"mfaToken":      token,
+		"username":      user.Username,
+	})
+}
+
+// bindMFARequest 解析请求并校验 MFA Token，返回对应用户
+func bindMFARequest(c *gin.Context, st *store.Store) (*MFARequest, model.User, bool) {
+	var req MFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+		return nil, model.User{}, false
+	}
+	username, ok := st.MFATokenUser(req.MFAToken)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "登录已过期，请重新输入密码"})
+		return nil, model.User{}, false
+	}
+	user, ok := st.GetUser(username)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "登录已过期，请重新输入密码"})
+		return nil, model.User{}, false
+	}
+	return &req, user, true
+}
+
+// LoginTOTP 登录第二步：校验验证码或恢复码（错误计入登录失败次数）
+func LoginTOTP(st *store.Store, guard *middleware.LoginGuard) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := config.Current()
+		req, user, ok := bindMFARequest(c, st)
+		if !ok {
+			return
+		}
+
+		ip := c.ClientIP()
+		if wait := guard.Check(user.Username, ip); wait > 0 {
+			respondLoginBlocked(c, wait)
+			return
+		}
+		if err := st.VerifySecondFactor(user.Username, req.Code); err != nil {
+			if errors.Is(err, store.ErrInvalidCode) {
+				guard.Fail(user.Username, ip)
+				recordAudit(c, st, user.Username, model.AuditLoginFailure, user.Username, false, gin.H{"reason": "invalid_code"})
+			}
+			respondTOTPError(c, err)
+			return
+		}
+
+		st.ConsumeMFAToken(req.MFAToken)
+		guard.Succeed(user.Username)
+		recordAudit(c, st, user.Username, model.AuditLoginSuccess, user.Username, true, gin.H{"method": "totp"})
+		startSession(c, cfg, st, user, nil)
+	}
+}
+
+// LoginTOTPSetup 被要求启用两步验证但尚未绑定的用户，登录时获取密钥
+func LoginTOTPSetup(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, user, ok := bindMFARequest(c, st)
+		if !ok {
+			return
+		}
+		respondTOTPSetup(c, st, user.Username)
+	}
+}
+
+// LoginTOTPActivate 登录时确认绑定，成功后直接登录并返回恢复码
+func LoginTOTPActivate(st *store.Store, guard *middleware.LoginGuard) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := config.Current()
+		req, user, ok := bindMFARequest(c, st)
+		if !ok {
+			return
+		}
+
+		codes, err := st.ActivateTOTP(user.Username, req.Code)
+		if err != nil {
+			respondTOTPError(c, err)
+			return
+		}
+
+		st.ConsumeMFAToken(req.MFAToken)
+		guard.Succeed(user.Username)
+		recordAudit(c, st, user.Username, model.AuditTOTPEnable, user.Username, true, nil)
+		recordAudit(c, st, user.Username, model.AuditLoginSuccess, user.Username, true, gin.H{"method": "totp"})
+		startSession(c, cfg, st, user, gin.H{"recoveryCodes": codes})
+	}
+}
+
+// respondTOTPSetup 生成待确认的密钥，返回 otpauth 地址
+func respondTOTPSetup(c *gin.Context, st *store.Store, username string) {
+	secret, err := st.BeginTOTPEnrollment(username)
+	if err != nil {
+		respondTOTPError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"secret":     secret,
+		"otpauthUri": totp.URI(totpIssuer, username, secret),
+	})
+}
+
+// GetTOTPStatus 获取当前用户的两步验证状态
+func GetTOTPStatus(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if rejectAPIToken(c) {
+			return
+		}
+		user, ok := st.GetUser(c.GetString("username"))
+		if !ok {
+			respondAdminError(c, store.ErrNotFound)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"enabled":       user.TOTPEnabled,
+			"required":      user.TOTPRequired,
+			"recoveryCodes": len(user.RecoveryCodes), // 剩余恢复码数量
+		})
+	}
+}
+
+// SetupTOTP 开始绑定两步验证，返回密钥和 otpauth 地址（需调用 ActivateTOTP 确认）
+func SetupTOTP(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if rejectAPIToken(c) {
+			return
+		}
+		respondTOTPSetup(c, st, c.GetString("username"))
+	}
+}
+
+// ActivateTOTP 用验证码确认绑定，返回恢复码（只显示一次）
+func ActivateTOTP(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if rejectAPIToken(c) {
+			return
+		}
+		var req TOTPCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+
+		codes, err := st.ActivateTOTP(c.GetString("username"), req.Code)
+		if err != nil {
+			respondTOTPError(c, err)
+			return
+		}
+		audit(c, st, model.AuditTOTPEnable, c.GetString("username"), nil)
+		c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+	}
+}
+
+// verifyGuardedSecondFactor 校验已登录用户的验证码或恢复码，错误与登录第二步一样计入失败次数（防止会话被盗后暴力猜测）
+func verifyGuardedSecondFactor(c *gin.Context, st *store.Store, guard *middleware.LoginGuard, username, code string) bool {
+	ip := c.ClientIP()
+	if wait := guard.Check(username, ip); wait > 0 {
+		respondLoginBlocked(c, wait)
+		return false
+	}
+	if err := st.VerifySecondFactor(username, code); err != nil {
+		if errors.Is(err, store.ErrInvalidCode) {
+			guard.Fail(username, ip)
+		}
+		respondTOTPError(c, err)
+		return false
+	}
+	guard.Succeed(username)
+	return true
+}
+
+// DisableTOTP 关闭两步验证（需提供验证码或恢复码）
+func DisableTOTP(st *store.Store, guard *middleware.LoginGuard) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if rejectAPIToken(c) {
+			return
+		}
+		var req TOTPCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+
+		username := c.GetString("username")
+		if !verifyGuardedSecondFactor(c, st, guard, username, req.Code) {
+			return
+		}
+		if err := st.DisableTOTP(username, false); err != nil {
+			respondTOTPError(c, err)
+			return
+		}
+		audit(c, st, model.AuditTOTPDisable, username, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "已关闭两步验证"})
+	}
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码（需提供验证码），旧恢复码作废
+func RegenerateRecoveryCodes(st *store.Store, guard *middleware.LoginGuard) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if rejectAPIToken(c) {
+			return
+		}
+		var req TOTPCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误"})
+			return
+		}
+
+		username := c.GetString("username")
+		if !verifyGuardedSecondFactor(c, st, guard, username, req.Code) {
+			return
+		}
+		codes, err := st.RegenerateRecoveryCodes(username)
+		if err != nil {
+			respondTOTPError(c, err)
+			return
+		}
+		audit(c, st, model.AuditTOTPRecoveryCodes, username, nil)
+		c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+	}
+}
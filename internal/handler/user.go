@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanxi/tracely/internal/model"
+	"gorm.io/gorm"
+)
+
+// GetUserTimeline 获取用户时间线接口（事件与错误按时间交错，before 为分页游标，取上一页的 nextBefore）
+func GetUserTimeline(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.Param("userId")
+		appID := c.Query("appID")
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+
+		if limit < 1 || limit > 200 {
+			limit = 50
+		}
+
+		before := model.TimelineCursor{Time: time.Now().Add(time.Second)}
+		if s := c.Query("before"); s != "" {
+			cursor, err := model.ParseTimelineCursor(s)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "before 参数错误"})
+				return
+			}
+			before = cursor
+		}
+
+		timeline, err := model.GetUserTimeline(db, appID, userID, before, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			return
+		}
+
+		if timeline.FirstSeen == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+			return
+		}
+
+		c.JSON(http.StatusOK, timeline)
+	}
+}
@@ -0,0 +1,62 @@
+// Package health 后台任务心跳，供 /readyz 检查任务是否仍在运行
+package health
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// worker 已注册的后台任务
+type worker struct {
+	interval time.Duration
+	lastBeat time.Time
+}
+
+var (
+	mu      sync.Mutex
+	workers = make(map[string]*worker)
+)
+
+// Register 注册后台任务并记录第一次心跳，interval 为任务执行间隔
+func Register(name string, interval time.Duration) {
+	mu.Lock()
+	workers[name] = &worker{interval: interval, lastBeat: time.Now()}
+	mu.Unlock()
+}
+
+// Beat 后台任务每次执行时调用
+func Beat(name string) {
+	mu.Lock()
+	if w, ok := workers[name]; ok {
+		w.lastBeat = time.Now()
+	}
+	mu.Unlock()
+}
+
+// WorkerStatus 后台任务状态
+type WorkerStatus struct {
+	Name     string    `json:"name"`
+	Healthy  bool      `json:"healthy"`
+	LastBeat time.Time `json:"lastBeat"`
+	Interval string    `json:"interval"`
+}
+
+// Workers 获取全部后台任务状态，超过两个执行间隔（至少 1 分钟）没有心跳视为停止
+func Workers() []WorkerStatus {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	list := make([]WorkerStatus, 0, len(workers))
+	for name, w := range workers {
+		list = append(list, WorkerStatus{
+			Name:     name,
+			Healthy:  now.Sub(w.lastBeat) <= max(2*w.interval, w.interval+time.Minute),
+			LastBeat: w.lastBeat,
+			Interval: w.interval.String(),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
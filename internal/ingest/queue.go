@@ -0,0 +1,180 @@
+// Package ingest 上报写入队列：请求校验通过后入队即返回，由单独的写入协程按批写入数据库
+package ingest
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/hanxi/tracely/internal/config"
+	"github.com/hanxi/tracely/internal/health"
+	"github.com/hanxi/tracely/internal/metrics"
+	"github.com/hanxi/tracely/internal/model"
+	"gorm.io/gorm"
+)
+
+// 入队失败的原因
+var (
+	ErrQueueFull = errors.New("ingest queue is full")
+	ErrClosed    = errors.New("ingest queue is closed")
+)
+
+// writerName 写入协程在健康检查中的名称
+const writerName = "ingest-writer"
+
+// item 队列中的一条上报（errorReport 和 event 二选一）
+type item struct {
+	errorReport *model.ErrorReport
+	event       *model.Event
+}
+
+func (it item) write(tx *gorm.DB) error {
+	if it.errorReport != nil {
+		return model.SaveErrorReport(tx, *it.errorReport)
+	}
+	// 复制一份再写入，整批回滚后逐条重试时不带上回滚前分配的 ID
+	// 事件时间取写入时间而不是入队时间：排队期间汇总任务的水位线可能已越过入队时间，这条事件就不会被汇总
+	event := *it.event
+	event.CreatedAt = time.Now()
+	return tx.Create(&event).Error
+}
+
+// Queue 上报写入队列
+type Queue struct {
+	db            *gorm.DB
+	items         chan item
+	batchSize     int
+	flushInterval time.Duration
+
+	mu     sync.RWMutex // 保护 closed，避免向已关闭的 channel 发送
+	closed bool
+	done   chan struct{}
+}
+
+// New 创建写入队列，需调用 Start 启动写入协程
+func New(db *gorm.DB, cfg config.Ingest) *Queue {
+	q := &Queue{
+		db:            db,
+		items:         make(chan item, cfg.QueueSize),
+		batchSize:     cfg.BatchSize,
+		flushInterval: time.Duration(cfg.FlushIntervalMs) * time.Millisecond,
+		done:          make(chan struct{}),
+	}
+	metrics.NewGaugeFunc("tracely_ingest_queue_length", "Reports waiting to be written to the database.", func() float64 {
+		return float64(q.Len())
+	})
+	return q
+}
+
+// Start 启动写入协程
+func (q *Queue) Start() {
+	health.Register(writerName, q.flushInterval)
+	go q.run()
+}
+
+// Len 当前排队等待写入的条数
+func (q *Queue) Len() int {
+	return len(q.items)
+}
+
+// EnqueueError 错误上报入队，队列已满时返回 ErrQueueFull
+func (q *Queue) EnqueueError(r model.ErrorReport) error {
+	return q.enqueue(item{errorReport: &r})
+}
+
+// EnqueueEvent 事件入队，队列已满时返回 ErrQueueFull
+func (q *Queue) EnqueueEvent(e *model.Event) error {
+	return q.enqueue(item{event: e})
+}
+
+func (q *Queue) enqueue(it item) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return ErrClosed
+	}
+	select {
+	case q.items <- it:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close 停止接收新的上报，等待队列中已有的上报全部写入（或 ctx 超时）
+func (q *Queue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.items)
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run 写入协程：攒满一批或到达刷新间隔时写入，队列关闭后写完剩余数据退出
+func (q *Queue) run() {
+	defer close(q.done)
+
+	ticker := time.NewTicker(q.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]item, 0, q.batchSize)
+	for {
+		select {
+		case it, ok := <-q.items:
+			if !ok {
+				q.flush(batch)
+				return
+			}
+			batch = append(batch, it)
+			if len(batch) >= q.batchSize {
+				q.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			health.Beat(writerName)
+			if len(batch) > 0 {
+				q.flush(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// flush 在一个事务中写入一批上报；整批失败时逐条重试，避免一条异常数据导致整批丢失
+func (q *Queue) flush(batch []item) {
+	if len(batch) == 0 {
+		return
+	}
+	metrics.IngestBatchSize.Observe(float64(len(batch)))
+
+	err := q.db.Transaction(func(tx *gorm.DB) error {
+		for _, it := range batch {
+			if err := it.write(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		return
+	}
+
+	slog.Warn("[Tracely] Ingest batch failed, retrying one by one", "size", len(batch), "error", err)
+	for _, it := range batch {
+		if err := q.db.Transaction(it.write); err != nil {
+			slog.Error("[Tracely] Failed to write report", "error", err)
+			metrics.IngestWriteFailures.Inc()
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 上报被拒绝的原因（tracely_reports_rejected_total 的 reason 标签）
+const (
+	ReasonRateLimited      = "rate_limited"
+	ReasonQueueFull        = "queue_full"
+	ReasonQuotaExceeded    = "quota_exceeded"
+	ReasonSpikeSampled     = "spike_sampled"
+	ReasonOriginNotAllowed = "origin_not_allowed"
+	ReasonMissingHeaders   = "missing_headers"
+	ReasonUnknownApp       = "unknown_app"
+	ReasonInvalidTimestamp = "invalid_timestamp"
+	ReasonExpired          = "expired"
+	ReasonNonceReused      = "nonce_reused"
+	ReasonInvalidSignature = "invalid_signature"
+	ReasonInvalidBody      = "invalid_body"
+	ReasonEventNotAllowed  = "event_not_allowed"
+	ReasonEventPending     = "event_pending"
+	ReasonInvalidMetadata  = "invalid_metadata"
+	ReasonDBError          = "db_error"
+)
+
+// 上报类型（tracely_reports_accepted_total 的 type 标签）
+const (
+	ReportError = "error"
+	ReportEvent = "event"
+)
+
+var (
+	// ReportsAccepted 按应用和类型统计已接收（进入写入队列）的上报
+	ReportsAccepted = NewCounter("tracely_reports_accepted_total",
+		"Reports accepted and queued for storage, by app and type.", "app_id", "type")
+
+	// ReportsRejected 按原因统计被拒绝的上报（限速、来源、签名校验和处理失败）
+	ReportsRejected = NewCounter("tracely_reports_rejected_total",
+		"Reports rejected before being stored, by reason.", "reason")
+
+	// EventValidationFailures 按事件和处理方式统计 metadata 校验失败的上报
+	EventValidationFailures = NewCounter("tracely_event_validation_failures_total",
+		"Event reports whose metadata failed schema validation, by event and mode.", "event", "mode")
+
+	// IngestWriteFailures 已接收但写入数据库失败（被丢弃）的上报
+	IngestWriteFailures = NewCounter("tracely_ingest_write_failures_total",
+		"Accepted reports that could not be written to the database.")
+
+	// IngestBatchSize 写入协程每个事务写入的条数
+	IngestBatchSize = NewHistogram("tracely_ingest_batch_size",
+		"Number of reports written per ingest transaction.", []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000})
+
+	// DBQueryDuration 数据库操作耗时
+	DBQueryDuration = NewHistogram("tracely_db_query_duration_seconds",
+		"Duration of database operations in seconds, by operation.", DefBuckets, "operation")
+)
+
+// dbStartKey gorm 实例中记录操作开始时间的键
+const dbStartKey = "metrics:start"
+
+// InstrumentDB 注册 gorm 回调，记录每类数据库操作的耗时
+func InstrumentDB(db *gorm.DB) error {
+	before := func(tx *gorm.DB) {
+		tx.InstanceSet(dbStartKey, time.Now())
+	}
+	after := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			if v, ok := tx.InstanceGet(dbStartKey); ok {
+				if start, ok := v.(time.Time); ok {
+					DBQueryDuration.Observe(time.Since(start).Seconds(), operation)
+				}
+			}
+		}
+	}
+
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().Before("gorm:create").Register("metrics:before_create", before),
+		cb.Create().After("gorm:create").Register("metrics:after_create", after("create")),
+		cb.Query().Before("gorm:query").Register("metrics:before_query", before),
+		cb.Query().After("gorm:query").Register("metrics:after_query", after("query")),
+		cb.Update().Before("gorm:update").Register("metrics:before_update", before),
+		cb.Update().After("gorm:update").Register("metrics:after_update", after("update")),
+		cb.Delete().Before("gorm:delete").Register("metrics:before_delete", before),
+		cb.Delete().After("gorm:delete").Register("metrics:after_delete", after("delete")),
+		cb.Row().Before("gorm:row").Register("metrics:before_row", before),
+		cb.Row().After("gorm:row").Register("metrics:after_row", after("row")),
+		cb.Raw().Before("gorm:raw").Register("metrics:before_raw", before),
+		cb.Raw().After("gorm:raw").Register("metrics:after_raw", after("raw")),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -11,13 +11,34 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/hanxi/tracely/internal/config"
+	"github.com/hanxi/tracely/internal/health"
+	"github.com/hanxi/tracely/internal/metrics"
+	"github.com/hanxi/tracely/internal/store"
 )
 
 // nonceStore 存储已使用的 Nonce
 var nonceStore = sync.Map{}
 
+func init() {
+	metrics.NewGaugeFunc("tracely_nonce_store_size", "Number of nonces currently held for replay protection.", func() float64 {
+		n := 0
+		nonceStore.Range(func(_, _ interface{}) bool {
+			n++
+			return true
+		})
+		return float64(n)
+	})
+}
+
+// RejectReport 拒绝上报请求并按原因计数（/metrics）
+func RejectReport(c *gin.Context, status int, msg, reason string) {
+	metrics.ReportsRejected.Inc(reason)
+	c.JSON(status, gin.H{"error": msg})
+	c.Abort()
+}
+
 // SignAuth HMAC 签名验证中间件
-func SignAuth(cfg *config.Config) gin.HandlerFunc {
+func SignAuth(st *store.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 1. 检查请求头是否存在
 		appID := c.GetHeader("X-App-Id")
@@ -26,51 +47,54 @@ func SignAuth(cfg *config.Config) gin.HandlerFunc {
 		signature := c.GetHeader("X-Signature")
 
 		if appID == "" || timestamp == "" || nonce == "" || signature == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "缺少认证请求头"})
-			c.Abort()
+			RejectReport(c, http.StatusUnauthorized, "缺少认证请求头", metrics.ReasonMissingHeaders)
 			return
 		}
 
-		// 2. 根据 AppID 查找 Secret
-		secret, ok := cfg.GetSecret(appID)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "非法 AppID"})
-			c.Abort()
+		// 2. 根据 AppID 查找有效的 Secret（轮换期间可能有多个）
+		secrets := st.ActiveSecrets(appID)
+		if len(secrets) == 0 {
+			RejectReport(c, http.StatusUnauthorized, "非法 AppID", metrics.ReasonUnknownApp)
 			return
 		}
 
 		// 3. 验证时间戳
 		ts, err := strconv.ParseInt(timestamp, 10, 64)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的时间戳"})
-			c.Abort()
+			RejectReport(c, http.StatusUnauthorized, "无效的时间戳", metrics.ReasonInvalidTimestamp)
 			return
 		}
 
 		now := time.Now().Unix()
-		if abs(now-ts) > int64(cfg.TimestampTTL) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "请求已过期"})
-			c.Abort()
+		if abs(now-ts) > int64(config.Current().TimestampTTL) {
+			RejectReport(c, http.StatusUnauthorized, "请求已过期", metrics.ReasonExpired)
 			return
 		}
 
 		// 4. 验证 Nonce 是否已使用（防重放）
 		if _, exists := nonceStore.Load(nonce); exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "重放攻击"})
-			c.Abort()
+			RejectReport(c, http.StatusUnauthorized, "重放攻击", metrics.ReasonNonceReused)
 			return
 		}
 		nonceStore.Store(nonce, time.Now())
 
-		// 5. 计算签名并比对
+		// 5. 计算签名并比对，任一有效 Secret 匹配即通过，并记录使用的密钥
 		raw := appID + timestamp + nonce
-		h := hmac.New(sha256.New, []byte(secret))
-		h.Write([]byte(raw))
-		expectedSig := hex.EncodeToString(h.Sum(nil))
+		matched := false
+		for _, secret := range secrets {
+			h := hmac.New(sha256.New, []byte(secret.Secret))
+			h.Write([]byte(raw))
+			expectedSig := hex.EncodeToString(h.Sum(nil))
 
-		if !hmac.Equal([]byte(signature), []byte(expectedSig)) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "签名错误"})
-			c.Abort()
+			if hmac.Equal([]byte(signature), []byte(expectedSig)) {
+				c.Set("appSecretId", secret.ID)
+				st.RecordSecretUse(secret.ID)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			RejectReport(c, http.StatusUnauthorized, "签名错误", metrics.ReasonInvalidSignature)
 			return
 		}
 
@@ -78,17 +102,20 @@ func SignAuth(cfg *config.Config) gin.HandlerFunc {
 	}
 }
 
-// StartNonceCleaner 启动定时清理过期 Nonce
-func StartNonceCleaner(ttl int) {
+// StartNonceCleaner 启动定时清理过期 Nonce（保留时长 nonceTTL 每次清理时读取当前配置）
+func StartNonceCleaner() {
+	health.Register("nonce-cleaner", 5*time.Minute)
 	go func() {
 		ticker := time.NewTicker(5 * time.Minute)
 		defer ticker.Stop()
 
 		for range ticker.C {
+			health.Beat("nonce-cleaner")
 			now := time.Now()
+			ttl := time.Duration(config.Current().NonceTTL) * time.Second
 			nonceStore.Range(func(key, value interface{}) bool {
 				if t, ok := value.(time.Time); ok {
-					if now.Sub(t) > time.Duration(ttl)*time.Second {
+					if now.Sub(t) > ttl {
 						nonceStore.Delete(key)
 					}
 				}
@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanxi/tracely/internal/config"
+	"github.com/hanxi/tracely/internal/metrics"
+	"github.com/hanxi/tracely/internal/store"
+)
+
+// 跨域允许的方法和请求头
+const (
+	corsAllowMethods       = "GET, POST, PUT, DELETE, OPTIONS"
+	corsDashboardHeaders   = "Origin, Content-Type, Authorization"
+	corsReportHeaders      = "Origin, Content-Type, X-App-Id, X-Timestamp, X-Nonce, X-Signature"
+	corsPreflightMaxAgeSec = "600"
+)
+
+// originAllowed 检查 Origin 是否在允许列表中
+func originAllowed(origin string, allowed []string) bool {
+	for _, o := range allowed {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// isSameOrigin 浏览器对同源的 POST 等请求也会携带 Origin，与请求的 Host 一致时视为同源
+func isSameOrigin(c *gin.Context, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, c.Request.Host)
+}
+
+// dashboardOrigins 允许跨域调用 Dashboard 接口的来源（已规范化）
+var dashboardOrigins atomic.Pointer[[]string]
+
+// SetDashboardOrigins 设置允许跨域调用 Dashboard 接口的来源（配置热加载时调用，来源已在加载配置时校验并规范化）
+func SetDashboardOrigins(origins []string) {
+	dashboard := append([]string{}, origins...)
+	dashboardOrigins.Store(&dashboard)
+}
+
+// CORS 跨域中间件，按路径使用不同的来源白名单（需注册为全局中间件，才能处理未注册路由的 OPTIONS 预检）
+//  1. /report：SDK 上报，按应用的 allowedOrigins 限制，应用未配置时允许任意来源；
+//     预检请求不携带 X-App-Id 的值，只要有任一应用允许该来源即放行，实际请求在签名验证之前按应用校验
+//  2. 其他路径（Dashboard 接口）：只允许同源和 dashboardOrigins 中配置的来源
+//
+// 不携带 Origin 的请求（服务端 SDK、脚本）不受限制
+func CORS(origins []string, st *store.Store) gin.HandlerFunc {
+	SetDashboardOrigins(origins)
+
+	return func(c *gin.Context) {
+		raw := c.GetHeader("Origin")
+		if raw == "" {
+			c.Next()
+			return
+		}
+		origin, _ := config.NormalizeOrigin(raw)
+		preflight := c.Request.Method == http.MethodOptions
+
+		var allowed bool
+		headers := corsDashboardHeaders
+		report := strings.HasPrefix(c.Request.URL.Path, "/report/")
+		if report {
+			headers = corsReportHeaders
+			if preflight {
+				allowed = reportOriginAllowedByAny(st, origin)
+			} else {
+				allowed = reportOriginAllowed(st, c.GetHeader("X-App-Id"), origin)
+			}
+		} else {
+			allowed = isSameOrigin(c, raw) || originAllowed(origin, *dashboardOrigins.Load())
+		}
+
+		c.Header("Vary", "Origin")
+		if !allowed {
+			if report {
+				RejectReport(c, http.StatusForbidden, "不允许的来源", metrics.ReasonOriginNotAllowed)
+				return
+			}
+			c.JSON(http.StatusForbidden, gin.H{"error": "不允许的来源"})
+			c.Abort()
+			return
+		}
+
+		c.Header("Access-Control-Allow-Origin", raw)
+		c.Header("Access-Control-Allow-Methods", corsAllowMethods)
+		c.Header("Access-Control-Allow-Headers", headers)
+		if preflight {
+			c.Header("Access-Control-Max-Age", corsPreflightMaxAgeSec)
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
+
+// reportOriginAllowed 检查应用是否允许该来源（应用不存在时交给签名验证返回错误）
+func reportOriginAllowed(st *store.Store, appID, origin string) bool {
+	app, ok := st.GetApp(appID)
+	if !ok {
+		return true
+	}
+	return len(app.AllowedOrigins) == 0 || originAllowed(origin, app.AllowedOrigins)
+}
+
+// reportOriginAllowedByAny 预检请求：任一应用允许该来源即放行
+func reportOriginAllowedByAny(st *store.Store, origin string) bool {
+	for _, app := range st.Apps() {
+		if len(app.AllowedOrigins) == 0 || originAllowed(origin, app.AllowedOrigins) {
+			return true
+		}
+	}
+	return false
+}
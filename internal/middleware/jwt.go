@@ -1,16 +1,20 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/hanxi/tracely/internal/config"
+	"github.com/hanxi/tracely/internal/model"
+	"github.com/hanxi/tracely/internal/store"
 )
 
-// JWTAuth JWT 验证中间件
-func JWTAuth(secret string) gin.HandlerFunc {
+// JWTAuth JWT 验证中间件（同时接受 tly_ 开头的个人 API Token）
+func JWTAuth(secret string, st *store.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 从 Authorization 头获取 Token
 		auth := c.GetHeader("Authorization")
@@ -21,6 +25,11 @@ func JWTAuth(secret string) gin.HandlerFunc {
 		}
 		tokenStr := strings.TrimPrefix(auth, "Bearer ")
 
+		if store.IsAPIToken(tokenStr) {
+			apiTokenAuth(c, st, tokenStr)
+			return
+		}
+
 		// 解析并验证 Token
 		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
 			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -35,24 +44,150 @@ func JWTAuth(secret string) gin.HandlerFunc {
 			return
 		}
 
-		// 将用户名写入上下文
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			if username, ok := claims["username"].(string); ok {
-				c.Set("username", username)
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token 无效或已过期"})
+			c.Abort()
+			return
+		}
+
+		// 检查吊销列表（不含 jti/sid 的旧版 Token 无法吊销，要求重新登录）
+		jti, _ := claims["jti"].(string)
+		sid, _ := claims["sid"].(string)
+		if jti == "" || sid == "" || st.IsAccessRevoked(jti, sid) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token 无效或已过期"})
+			c.Abort()
+			return
+		}
+		c.Set("jti", jti)
+		c.Set("sessionId", sid)
+		if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+			c.Set("tokenExpiresAt", exp.Time)
+		}
+
+		// 将用户名、角色和允许访问的应用写入上下文
+		if username, ok := claims["username"].(string); ok {
+			c.Set("username", username)
+		}
+		if role, ok := claims["role"].(string); ok {
+			c.Set("role", role)
+		}
+		if apps, ok := claims["apps"].([]interface{}); ok {
+			allowed := make([]string, 0, len(apps))
+			for _, app := range apps {
+				if appID, ok := app.(string); ok {
+					allowed = append(allowed, appID)
+				}
 			}
+			c.Set("apps", allowed)
 		}
 
 		c.Next()
 	}
 }
 
-// GenerateToken 生成 JWT Token
-func GenerateToken(secret string, username string, expireHours int) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+// apiTokenAuth 校验个人 API Token，按 Token 权限范围和用户当前权限取交集写入上下文
+func apiTokenAuth(c *gin.Context, st *store.Store, raw string) {
+	token, user, err := st.AuthenticateAPIToken(raw)
+	if err != nil {
+		if errors.Is(err, store.ErrInvalidToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token 无效或已过期"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "验证 Token 失败"})
+		}
+		c.Abort()
+		return
+	}
+
+	role := scopeRole(token)
+	if roleLevel(user.GetRole()) < roleLevel(role) {
+		role = user.GetRole()
+	}
+
+	// Token 限定了应用时不能调用管理接口（管理接口不区分应用）
+	apps := user.AllowedApps()
+	if len(token.Apps) > 0 {
+		apps = intersectApps(apps, token.Apps)
+		if role == config.RoleAdmin {
+			role = config.RoleMember
+		}
+	}
+
+	c.Set("username", user.Username)
+	c.Set("role", role)
+	if apps != nil {
+		c.Set("apps", apps)
+	}
+	c.Set("apiTokenId", token.ID)
+	c.Next()
+}
+
+// scopeRole 将 Token 权限范围映射为对应角色（取最高的一个）
+func scopeRole(token *model.APIToken) string {
+	switch {
+	case token.HasScope(model.ScopeAdmin):
+		return config.RoleAdmin
+	case token.HasScope(model.ScopeWrite):
+		return config.RoleMember
+	default:
+		return config.RoleViewer
+	}
+}
+
+// roleLevel 角色权限高低
+func roleLevel(role string) int {
+	switch role {
+	case config.RoleAdmin:
+		return 3
+	case config.RoleMember:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// intersectApps 计算应用列表交集，allowed 为 nil 表示不限制
+func intersectApps(allowed, requested []string) []string {
+	result := make([]string, 0, len(requested))
+	for _, appID := range requested {
+		if allowed == nil || contains(allowed, appID) {
+			result = append(result, appID)
+		}
+	}
+	return result
+}
+
+// contains 检查字符串切片是否包含指定值
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAPITokenRequest 当前请求是否通过 API Token 认证
+func IsAPITokenRequest(c *gin.Context) bool {
+	_, ok := c.Get("apiTokenId")
+	return ok
+}
+
+// GenerateToken 生成 Access Token（JWT）
+// apps 为允许访问的 AppID 列表，nil 表示不限制；sessionID 和 jti 用于吊销
+func GenerateToken(secret string, username string, role string, apps []string, sessionID string, jti string, expiresAt time.Time) (string, error) {
+	claims := jwt.MapClaims{
 		"username": username,
-		"exp":      time.Now().Add(time.Duration(expireHours) * time.Hour).Unix(),
+		"role":     role,
+		"sid":      sessionID,
+		"jti":      jti,
+		"exp":      expiresAt.Unix(),
 		"iat":      time.Now().Unix(),
-	})
+	}
+	if apps != nil {
+		claims["apps"] = apps
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	return token.SignedString([]byte(secret))
 }
@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/hanxi/tracely/internal/config"
+	"github.com/hanxi/tracely/internal/health"
+)
+
+// failureRecord 连续登录失败记录
+type failureRecord struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time // 退避或锁定截止时间，之前的登录请求直接拒绝
+}
+
+// guardPolicy 退避和锁定阈值
+type guardPolicy struct {
+	free    int // 超过后开始指数退避
+	lockout int // 达到后临时锁定
+}
+
+// LoginGuard 登录防暴力破解：按用户名和 IP 统计连续失败次数，
+// 超过阈值后指数退避（1s、2s、4s... 不超过上限），继续失败则临时锁定
+type LoginGuard struct {
+	mu         sync.Mutex
+	users      map[string]*failureRecord
+	ips        map[string]*failureRecord
+	userPolicy guardPolicy
+	ipPolicy   guardPolicy
+	maxBackoff time.Duration
+	lockout    time.Duration
+}
+
+// NewLoginGuard 创建登录防护
+func NewLoginGuard(cfg config.LoginGuard) *LoginGuard {
+	g := &LoginGuard{
+		users: make(map[string]*failureRecord),
+		ips:   make(map[string]*failureRecord),
+	}
+	g.SetConfig(cfg)
+	return g
+}
+
+// SetConfig 修改退避和锁定阈值（配置热加载），已有的失败记录保留
+func (g *LoginGuard) SetConfig(cfg config.LoginGuard) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.userPolicy = guardPolicy{free: cfg.FreeAttempts, lockout: cfg.LockoutAttempts}
+	g.ipPolicy = guardPolicy{free: cfg.IPFreeAttempts, lockout: cfg.IPLockoutAttempts}
+	g.maxBackoff = time.Duration(cfg.MaxBackoffSeconds) * time.Second
+	g.lockout = time.Duration(cfg.LockoutMinutes) * time.Minute
+}
+
+// Check 登录前检查，返回需要等待的时间（0 表示允许尝试）
+// 不区分用户名是否存在，避免通过锁定状态枚举用户
+func (g *LoginGuard) Check(username, ip string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	for _, r := range []*failureRecord{g.users[username], g.ips[ip]} {
+		if r != nil && r.blockedUntil.After(now) {
+			wait = max(wait, r.blockedUntil.Sub(now))
+		}
+	}
+	return wait
+}
+
+// Fail 记录一次登录失败（密码或两步验证码错误）
+func (g *LoginGuard) Fail(username, ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	if g.fail(g.users, username, g.userPolicy, now) {
+		slog.Warn("[Tracely] Login locked out", "username", username, "ip", ip, "until", now.Add(g.lockout))
+	}
+	if g.fail(g.ips, ip, g.ipPolicy, now) {
+		slog.Warn("[Tracely] Login locked out for IP", "ip", ip, "username", username, "until", now.Add(g.lockout))
+	}
+}
+
+// fail 增加失败次数并计算退避时间，返回是否刚进入锁定
+func (g *LoginGuard) fail(records map[string]*failureRecord, key string, p guardPolicy, now time.Time) bool {
+	r, ok := records[key]
+	if !ok || now.Sub(r.lastFailure) > g.lockout {
+		r = &failureRecord{}
+		records[key] = r
+	}
+	r.failures++
+	r.lastFailure = now
+
+	switch {
+	case r.failures >= p.lockout:
+		r.blockedUntil = now.Add(g.lockout)
+		return r.failures == p.lockout
+	case r.failures >= p.free:
+		r.blockedUntil = now.Add(g.backoff(r.failures - p.free))
+	}
+	return false
+}
+
+// backoff 第 n 次退避的等待时间（从 1 秒开始翻倍）
+func (g *LoginGuard) backoff(n int) time.Duration {
+	if n >= 16 {
+		return g.maxBackoff
+	}
+	return min(time.Second<<n, g.maxBackoff)
+}
+
+// Succeed 登录成功，清除该用户名的失败记录（IP 记录保留到过期，防止用已知账号重置计数）
+func (g *LoginGuard) Succeed(username string) {
+	g.mu.Lock()
+	delete(g.users, username)
+	g.mu.Unlock()
+}
+
+// StartCleaner 启动定时清理过期的失败记录
+func (g *LoginGuard) StartCleaner() {
+	health.Register("login-guard-cleaner", 5*time.Minute)
+	go func() {
+		ticker := time.NewTicker(5 * time.Minute)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			health.Beat("login-guard-cleaner")
+			g.mu.Lock()
+			now := time.Now()
+			for _, records := range []map[string]*failureRecord{g.users, g.ips} {
+				for k, r := range records {
+					if now.Sub(r.lastFailure) > g.lockout && now.After(r.blockedUntil) {
+						delete(records, k)
+					}
+				}
+			}
+			g.mu.Unlock()
+		}
+	}()
+}
@@ -1,46 +1,234 @@
 package middleware
 
 import (
+	"container/list"
+	"math"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/hanxi/tracely/internal/config"
+	"github.com/hanxi/tracely/internal/health"
+	"github.com/hanxi/tracely/internal/metrics"
 )
 
-// ipStore 存储每个 IP 的请求时间戳
-var ipStore = sync.Map{}
+// rateLimitRemainingKey 上下文中记录已写入响应头的剩余令牌数
+const rateLimitRemainingKey = "rateLimitRemaining"
 
-// RateLimit IP 限速中间件（滑动窗口算法）
-func RateLimit(maxPerMin int) gin.HandlerFunc {
+// tokenBucket 令牌桶，tokens 为上次取令牌后剩余的数量
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// bucketSet 一类限速对象（IP 或应用）的令牌桶
+// lru 按最近使用排序（队首最新），桶数量达到上限时淘汰队尾，不需要在请求路径上遍历
+type bucketSet struct {
+	mu         sync.Mutex
+	rate       float64 // 每秒补充的令牌数
+	burst      float64 // 桶容量
+	maxEntries int     // 最多跟踪的桶数量
+	buckets    map[string]*list.Element
+	lru        *list.List
+}
+
+func newBucketSet(perMinute, burst, maxEntries int) *bucketSet {
+	return &bucketSet{
+		rate:       float64(perMinute) / 60,
+		burst:      float64(burst),
+		maxEntries: maxEntries,
+		buckets:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// takeResult 一次取令牌的结果（在锁内计算，与热加载修改的参数一致）
+type takeResult struct {
+	allowed   bool
+	remaining float64       // 剩余令牌数
+	limit     int           // 桶容量
+	reset     time.Duration // 补满所需时间
+	wait      time.Duration // 被拒绝时下一个令牌的等待时间
+}
+
+// take 取一个令牌
+// 桶数量达到上限时淘汰最久未使用的桶，避免内存无限增长，也不会因表满拒绝新的来源
+func (s *bucketSet) take(key string, now time.Time) takeResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b *tokenBucket
+	if e, ok := s.buckets[key]; ok {
+		s.lru.MoveToFront(e)
+		b = e.Value.(*tokenBucket)
+		b.tokens = min(s.burst, b.tokens+now.Sub(b.last).Seconds()*s.rate)
+		b.last = now
+	} else {
+		s.trim(s.maxEntries - 1)
+		b = &tokenBucket{key: key, tokens: s.burst, last: now}
+		s.buckets[key] = s.lru.PushFront(b)
+	}
+
+	if b.tokens < 1 {
+		return s.result(false, b.tokens)
+	}
+	b.tokens--
+	return s.result(true, b.tokens)
+}
+
+// result 根据剩余令牌数生成取令牌结果，调用方需持有锁
+func (s *bucketSet) result(allowed bool, remaining float64) takeResult {
+	r := takeResult{
+		allowed:   allowed,
+		remaining: remaining,
+		limit:     int(s.burst),
+		reset:     s.refill(remaining, s.burst),
+	}
+	if !allowed {
+		r.wait = s.refill(remaining, 1)
+	}
+	return r
+}
+
+// refill 令牌从 tokens 补充到 target 所需的时间，调用方需持有锁
+func (s *bucketSet) refill(tokens, target float64) time.Duration {
+	if tokens >= target {
+		return 0
+	}
+	return time.Duration((target - tokens) / s.rate * float64(time.Second))
+}
+
+// trim 从队尾淘汰最久未使用的桶，直到数量不超过 n，调用方需持有锁
+func (s *bucketSet) trim(n int) {
+	for len(s.buckets) > n {
+		s.remove(s.lru.Back())
+	}
+}
+
+// remove 删除一个桶，调用方需持有锁
+func (s *bucketSet) remove(e *list.Element) {
+	s.lru.Remove(e)
+	delete(s.buckets, e.Value.(*tokenBucket).key)
+}
+
+// evictIdle 清理已经补满的桶（与新建的桶等价，删除不影响限速结果），调用方需持有锁
+func (s *bucketSet) evictIdle(now time.Time) {
+	for e := s.lru.Back(); e != nil; {
+		prev := e.Prev()
+		b := e.Value.(*tokenBucket)
+		if b.tokens+now.Sub(b.last).Seconds()*s.rate >= s.burst {
+			s.remove(e)
+		}
+		e = prev
+	}
+}
+
+// len 当前跟踪的桶数量
+func (s *bucketSet) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buckets)
+}
+
+// RateLimiter 上报限速（令牌桶）：按客户端 IP 和应用分别限速，
+// 同一 NAT 出口的多个客户端不会共用应用的额度，使用大量 IP 的应用也会被限住
+type RateLimiter struct {
+	ips  *bucketSet
+	apps *bucketSet
+}
+
+// NewRateLimiter 创建上报限速器
+func NewRateLimiter(cfg config.RateLimiter) *RateLimiter {
+	l := &RateLimiter{
+		ips:  newBucketSet(cfg.IPPerMinute, cfg.IPBurst, cfg.MaxEntries),
+		apps: newBucketSet(cfg.AppPerMinute, cfg.AppBurst, cfg.MaxEntries),
+	}
+	metrics.NewGaugeFunc("tracely_ratelimit_buckets", "Number of rate limit buckets currently tracked (IP and app).", func() float64 {
+		return float64(l.ips.len() + l.apps.len())
+	})
+	return l
+}
+
+// configure 修改补充速率、桶容量和数量上限，已有桶的令牌数不超过新容量，超出新上限的桶按最久未使用淘汰
+func (s *bucketSet) configure(perMinute, burst, maxEntries int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rate = float64(perMinute) / 60
+	s.burst = float64(burst)
+	s.maxEntries = maxEntries
+	s.trim(maxEntries)
+	for e := s.lru.Front(); e != nil; e = e.Next() {
+		b := e.Value.(*tokenBucket)
+		b.tokens = min(b.tokens, s.burst)
+	}
+}
+
+// SetConfig 修改限速参数（配置热加载）
+func (l *RateLimiter) SetConfig(cfg config.RateLimiter) {
+	l.ips.configure(cfg.IPPerMinute, cfg.IPBurst, cfg.MaxEntries)
+	l.apps.configure(cfg.AppPerMinute, cfg.AppBurst, cfg.MaxEntries)
+}
+
+// ByIP 按客户端 IP 限速（在签名验证之前，拦截无效请求的洪泛）
+func (l *RateLimiter) ByIP() gin.HandlerFunc {
+	return limitBy(l.ips, func(c *gin.Context) string {
+		return c.ClientIP()
+	})
+}
+
+// ByApp 按应用限速，需放在签名验证之后（X-App-Id 已验证，无法冒用其他应用的额度）
+func (l *RateLimiter) ByApp() gin.HandlerFunc {
+	return limitBy(l.apps, func(c *gin.Context) string {
+		return c.GetHeader("X-App-Id")
+	})
+}
+
+// limitBy 限速中间件，写入 X-RateLimit-Limit / Remaining / Reset 响应头，被拒绝时附带 Retry-After
+// 同时经过 IP 和应用限速时，响应头取剩余令牌较少的一个
+func limitBy(s *bucketSet, key func(*gin.Context) string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ip := c.ClientIP()
-		now := time.Now()
-		windowStart := now.Add(-time.Minute)
-
-		// 获取该 IP 的请求时间戳列表
-		raw, _ := ipStore.LoadOrStore(ip, &[]time.Time{})
-		timestamps := raw.(*[]time.Time)
-
-		// 过滤掉 60 秒之前的记录
-		valid := make([]time.Time, 0, len(*timestamps))
-		for _, ts := range *timestamps {
-			if ts.After(windowStart) {
-				valid = append(valid, ts)
-			}
+		r := s.take(key(c), time.Now())
+
+		if prev, ok := c.Get(rateLimitRemainingKey); !ok || r.remaining < prev.(float64) {
+			c.Set(rateLimitRemainingKey, r.remaining)
+			c.Header("X-RateLimit-Limit", strconv.Itoa(r.limit))
+			c.Header("X-RateLimit-Remaining", strconv.Itoa(int(r.remaining)))
+			c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(r.reset)))
 		}
 
-		// 判断是否超过限制
-		if len(valid) >= maxPerMin {
-			c.JSON(http.StatusTooManyRequests, gin.H{"error": "请求过于频繁"})
-			c.Abort()
+		if !r.allowed {
+			c.Header("Retry-After", strconv.Itoa(max(1, ceilSeconds(r.wait))))
+			RejectReport(c, http.StatusTooManyRequests, "请求过于频繁", metrics.ReasonRateLimited)
 			return
 		}
-
-		// 添加当前请求时间戳
-		valid = append(valid, now)
-		ipStore.Store(ip, &valid)
-
 		c.Next()
 	}
 }
+
+// StartCleaner 启动定时清理已补满（长时间没有请求）的桶
+func (l *RateLimiter) StartCleaner() {
+	health.Register("rate-limit-cleaner", time.Minute)
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			health.Beat("rate-limit-cleaner")
+			now := time.Now()
+			for _, s := range []*bucketSet{l.ips, l.apps} {
+				s.mu.Lock()
+				s.evictIdle(now)
+				s.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// ceilSeconds 向上取整的秒数
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
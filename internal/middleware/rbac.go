@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanxi/tracely/internal/config"
+)
+
+// GetRole 获取当前用户角色（上下文中没有角色时按权限最低的 viewer 处理）
+func GetRole(c *gin.Context) string {
+	if role := c.GetString("role"); role != "" {
+		return role
+	}
+	return config.RoleViewer
+}
+
+// AllowedApps 获取当前用户允许访问的 AppID 列表，nil 表示不限制
+func AllowedApps(c *gin.Context) []string {
+	if GetRole(c) == config.RoleAdmin {
+		return nil
+	}
+	apps, ok := c.Get("apps")
+	if !ok {
+		return nil
+	}
+	return apps.([]string)
+}
+
+// CanAccessApp 检查当前用户是否可以访问指定应用
+func CanAccessApp(c *gin.Context, appID string) bool {
+	allowed := AllowedApps(c)
+	return allowed == nil || contains(allowed, appID)
+}
+
+// selfServicePaths 用户管理自身资源的接口，不受角色和应用限制
+var selfServicePaths = map[string]bool{
+	"/api/tokens":                      true,
+	"/api/tokens/:tokenId":             true,
+	"/api/account/totp":                true,
+	"/api/account/totp/setup":          true,
+	"/api/account/totp/activate":       true,
+	"/api/account/totp/recovery-codes": true,
+}
+
+// Authorize Dashboard 接口权限中间件（需在 JWTAuth 之后使用）
+// 1. Token 不含角色时拒绝访问，viewer 只能调用只读接口
+// 2. 受限用户必须通过 appID 参数（或路径参数 :appId）指定允许访问的应用
+func Authorize() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if selfServicePaths[c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		if c.GetString("role") == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Token 缺少角色，请重新登录"})
+			c.Abort()
+			return
+		}
+		if GetRole(c) == config.RoleViewer && c.Request.Method != http.MethodGet {
+			c.JSON(http.StatusForbidden, gin.H{"error": "无操作权限"})
+			c.Abort()
+			return
+		}
+
+		if AllowedApps(c) != nil {
+			appID := c.Query("appID")
+			if appID == "" {
+				appID = c.Param("appId")
+			}
+
+			// GET /api/apps 由接口自身按权限过滤
+			if appID == "" && c.FullPath() != "/api/apps" {
+				c.JSON(http.StatusForbidden, gin.H{"error": "请指定 appID"})
+				c.Abort()
+				return
+			}
+			if appID != "" && !CanAccessApp(c, appID) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "无权访问该应用"})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// RequireRole 限制只有指定角色才能访问
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := GetRole(c)
+		for _, r := range roles {
+			if r == role {
+				c.Next()
+				return
+			}
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "无操作权限"})
+		c.Abort()
+	}
+}
@@ -0,0 +1,207 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 活跃窗口长度（天）
+const (
+	weeklyWindowDays  = 7
+	monthlyWindowDays = 30
+)
+
+// ActiveUserPoint 每日活跃用户指标
+type ActiveUserPoint struct {
+	Date       string  `json:"date"`
+	DAU        int64   `json:"dau"`        // 日活
+	WAU        int64   `json:"wau"`        // 滚动 7 日活跃
+	MAU        int64   `json:"mau"`        // 滚动 30 日活跃
+	Stickiness float64 `json:"stickiness"` // 粘性 DAU/MAU
+}
+
+// activeUserDay 某日某用户的活跃记录
+type activeUserDay struct {
+	Date   string
+	UserID string
+}
+
+// GetActiveUsers 获取日期区间内每日的 DAU/WAU/MAU 及粘性（基于 _active 事件）
+// start、end 为 UTC 日期（含首尾），与 DATE(created_at) 的分组口径一致
+func GetActiveUsers(db *gorm.DB, appID string, start, end time.Time) ([]ActiveUserPoint, error) {
+	// 向前多取 29 天，保证区间首日的 MAU 窗口完整
+	since := start.AddDate(0, 0, -(monthlyWindowDays - 1))
+	until := end.AddDate(0, 0, 1)
+
+	query := db.Model(&Event{}).
+		Select("DATE(created_at) as date, user_id").
+		Where("event_name = ? AND created_at >= ? AND created_at < ?", EVENT_ACTIVE, since, until)
+
+	if appID != "" {
+		query = query.Where("app_id = ?", appID)
+	}
+
+	var rows []activeUserDay
+	if err := query.Group("DATE(created_at), user_id").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	// 按日期归集用户
+	usersByDay := make(map[string][]string)
+	for _, row := range rows {
+		usersByDay[row.Date] = append(usersByDay[row.Date], row.UserID)
+	}
+
+	// 滑动窗口：记录窗口内每个用户出现的天数
+	weekly := make(map[string]int)
+	monthly := make(map[string]int)
+	add := func(window map[string]int, day time.Time) {
+		for _, u := range usersByDay[day.Format("2006-01-02")] {
+			window[u]++
+		}
+	}
+	remove := func(window map[string]int, day time.Time) {
+		for _, u := range usersByDay[day.Format("2006-01-02")] {
+			if window[u]--; window[u] <= 0 {
+				delete(window, u)
+			}
+		}
+	}
+
+	points := make([]ActiveUserPoint, 0, int(end.Sub(start).Hours()/24)+1)
+	for day := since; !day.After(end); day = day.AddDate(0, 0, 1) {
+		add(weekly, day)
+		add(monthly, day)
+		if old := day.AddDate(0, 0, -weeklyWindowDays); !old.Before(since) {
+			remove(weekly, old)
+		}
+		if old := day.AddDate(0, 0, -monthlyWindowDays); !old.Before(since) {
+			remove(monthly, old)
+		}
+
+		if day.Before(start) {
+			continue
+		}
+
+		date := day.Format("2006-01-02")
+		point := ActiveUserPoint{
+			Date: date,
+			DAU:  int64(len(usersByDay[date])),
+			WAU:  int64(len(weekly)),
+			MAU:  int64(len(monthly)),
+		}
+		if point.MAU > 0 {
+			point.Stickiness = float64(point.DAU) / float64(point.MAU)
+		}
+		points = append(points, point)
+	}
+
+	return points, nil
+}
+
+// InstanceFilter 实例查询条件（基于 Go SDK 心跳元数据）
+type InstanceFilter struct {
+	AppID    string
+	Start    time.Time
+	End      time.Time // 不含
+	TagKey   string    // 按 tags.<key> 过滤
+	TagValue string
+}
+
+// apply 将过滤条件应用到 _active 事件查询上（只统计带 instanceId 的心跳）
+func (f InstanceFilter) apply(query *gorm.DB) *gorm.DB {
+	query = query.Where("event_name = ? AND created_at >= ? AND created_at < ?", EVENT_ACTIVE, f.Start, f.End).
+		Where("json_extract(metadata, '$.instanceId') IS NOT NULL")
+
+	if f.AppID != "" {
+		query = query.Where("app_id = ?", f.AppID)
+	}
+	if f.TagKey != "" {
+		query = query.Where("json_extract(metadata, ?) = ?", "$.tags."+f.TagKey, f.TagValue)
+	}
+	return query
+}
+
+// InstancePoint 某时间桶内的在线实例数
+type InstancePoint struct {
+	Time      string `json:"time"`
+	Instances int64  `json:"instances"`
+}
+
+// GetConcurrentInstances 获取并发实例数趋势
+// interval 为 hour 或 day，同一时间桶内上报过心跳的实例视为在线
+func GetConcurrentInstances(db *gorm.DB, filter InstanceFilter, interval string) ([]InstancePoint, error) {
+	bucket := "strftime('%Y-%m-%d %H:00', created_at)"
+	if interval == "day" {
+		bucket = "DATE(created_at)"
+	}
+
+	query := filter.apply(db.Model(&Event{})).
+		Select(bucket + " as time, COUNT(DISTINCT json_extract(metadata, '$.instanceId')) as instances")
+
+	var points []InstancePoint
+	err := query.Group(bucket).Order("time ASC").Scan(&points).Error
+	return points, err
+}
+
+// InstanceSummary 单个实例的心跳汇总
+type InstanceSummary struct {
+	InstanceID string          `json:"instanceId"`
+	UserID     string          `json:"userId"`
+	Tags       json.RawMessage `json:"tags"`
+	FirstSeen  string          `json:"firstSeen"`
+	LastSeen   string          `json:"lastSeen"`
+	Uptime     int64           `json:"uptime"` // 最大运行时长（秒）
+	Heartbeats int64           `json:"heartbeats"`
+}
+
+// instanceRow 实例汇总查询结果（tags 由 json_extract 返回文本）
+type instanceRow struct {
+	InstanceID string
+	UserID     string
+	Tags       string
+	FirstSeen  string
+	LastSeen   string
+	Uptime     int64
+	Heartbeats int64
+}
+
+// GetInstances 获取实例列表（按最近心跳倒序）
+func GetInstances(db *gorm.DB, filter InstanceFilter, limit int) ([]InstanceSummary, error) {
+	query := filter.apply(db.Model(&Event{})).
+		Select("json_extract(metadata, '$.instanceId') as instance_id, " +
+			"MAX(user_id) as user_id, " +
+			"COALESCE(MAX(json_extract(metadata, '$.tags')), '') as tags, " +
+			"MIN(created_at) as first_seen, " +
+			"MAX(created_at) as last_seen, " +
+			"MAX(CAST(json_extract(metadata, '$.duration') AS INTEGER)) as uptime, " +
+			"COUNT(*) as heartbeats")
+
+	var rows []instanceRow
+	err := query.Group("json_extract(metadata, '$.instanceId')").
+		Order("last_seen DESC").
+		Limit(limit).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([]InstanceSummary, 0, len(rows))
+	for _, row := range rows {
+		instance := InstanceSummary{
+			InstanceID: row.InstanceID,
+			UserID:     row.UserID,
+			FirstSeen:  row.FirstSeen,
+			LastSeen:   row.LastSeen,
+			Uptime:     row.Uptime,
+			Heartbeats: row.Heartbeats,
+		}
+		if row.Tags != "" {
+			instance.Tags = json.RawMessage(row.Tags)
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// API Token 权限范围
+const (
+	ScopeRead  = "read"  // 只读接口
+	ScopeWrite = "write" // 读写接口（不含管理接口）
+	ScopeAdmin = "admin" // 全部接口（仅管理员可创建）
+)
+
+// IsValidScope 检查权限范围是否合法
+func IsValidScope(scope string) bool {
+	return scope == ScopeRead || scope == ScopeWrite || scope == ScopeAdmin
+}
+
+// APIToken 个人 API Token（脚本、CI 调用 Dashboard 接口用），数据库只保存哈希
+type APIToken struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	Username   string     `gorm:"index" json:"username"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // Token 前几位，便于识别
+	TokenHash  string     `gorm:"uniqueIndex" json:"-"`
+	Scopes     []string   `gorm:"serializer:json" json:"scopes"`
+	Apps       []string   `gorm:"serializer:json" json:"apps"` // 进一步限制可访问的应用，为空表示与用户一致
+	ExpiresAt  *time.Time `json:"expiresAt"`                   // 为空表示永不过期
+	RevokedAt  *time.Time `json:"revokedAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// IsActive 检查 Token 在指定时间是否有效（未吊销且未过期）
+func (t *APIToken) IsActive(now time.Time) bool {
+	if t.RevokedAt != nil {
+		return false
+	}
+	return t.ExpiresAt == nil || now.Before(*t.ExpiresAt)
+}
+
+// HasScope 检查 Token 是否包含指定权限范围
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAPITokenByHash 根据哈希获取 Token
+func GetAPITokenByHash(db *gorm.DB, hash string) (*APIToken, error) {
+	var token APIToken
+	if err := db.Where("token_hash = ?", hash).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ListAPITokens 获取用户的全部 Token
+func ListAPITokens(db *gorm.DB, username string) ([]APIToken, error) {
+	var tokens []APIToken
+	err := db.Where("username = ?", username).Order("id ASC").Find(&tokens).Error
+	return tokens, err
+}
+
+// TouchAPIToken 更新 Token 最后使用时间
+func TouchAPIToken(db *gorm.DB, id uint, usedAt time.Time) error {
+	return db.Model(&APIToken{}).Where("id = ?", id).Update("last_used_at", usedAt).Error
+}
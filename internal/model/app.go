@@ -0,0 +1,65 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// App 应用（SDK 上报用，签名密钥见 AppSecret）
+type App struct {
+	ID             uint      `gorm:"primaryKey" json:"-"`
+	AppID          string    `gorm:"uniqueIndex" json:"appId"`
+	AppName        string    `json:"appName"`
+	AllowedOrigins []string  `gorm:"serializer:json" json:"allowedOrigins"` // 浏览器 SDK 允许的来源（如 https://example.com），为空表示不限制
+	Quota          AppQuota  `gorm:"embedded;embeddedPrefix:quota_" json:"quota"`
+	Scrub          AppScrub  `gorm:"serializer:json" json:"scrub"` // 覆盖全局脱敏规则
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// AppQuota 应用上报配额，按 UTC 自然日和自然月计算，0 表示不限制
+type AppQuota struct {
+	DailyEvents   int64 `json:"dailyEvents"`
+	MonthlyEvents int64 `json:"monthlyEvents"`
+	DailyErrors   int64 `json:"dailyErrors"`
+	MonthlyErrors int64 `json:"monthlyErrors"`
+}
+
+// Valid 配额不能为负数
+func (q AppQuota) Valid() bool {
+	return q.DailyEvents >= 0 && q.MonthlyEvents >= 0 && q.DailyErrors >= 0 && q.MonthlyErrors >= 0
+}
+
+// Limits 指定上报类型的每日和每月配额
+func (q AppQuota) Limits(kind string) (daily, monthly int64) {
+	if kind == UsageKindError {
+		return q.DailyErrors, q.MonthlyErrors
+	}
+	return q.DailyEvents, q.MonthlyEvents
+}
+
+// AppScrub 应用的脱敏规则覆盖：Enabled 和 Detectors 为空时沿用全局配置，
+// Patterns 和 DenyKeys 在全局规则基础上追加
+type AppScrub struct {
+	Enabled   *bool     `json:"enabled,omitempty"`
+	Detectors *[]string `json:"detectors,omitempty"`
+	Patterns  []string  `json:"patterns,omitempty"`
+	DenyKeys  []string  `json:"denyKeys,omitempty"`
+}
+
+// ListApps 获取全部应用
+func ListApps(db *gorm.DB) ([]App, error) {
+	var apps []App
+	err := db.Order("id ASC").Find(&apps).Error
+	return apps, err
+}
+
+// GetApp 根据 AppID 获取应用
+func GetApp(db *gorm.DB, appID string) (*App, error) {
+	var app App
+	if err := db.Where("app_id = ?", appID).First(&app).Error; err != nil {
+		return nil, err
+	}
+	return &app, nil
+}
@@ -0,0 +1,74 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AppSecret 应用签名密钥（一个应用可同时持有多个有效密钥，用于平滑轮换）
+type AppSecret struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	AppID      string     `gorm:"index" json:"appId"`
+	Name       string     `json:"name"` // 备注，如 "2024-rotation"
+	Secret     string     `json:"-"`
+	ExpiresAt  *time.Time `json:"expiresAt"` // 为空表示永不过期
+	RevokedAt  *time.Time `json:"revokedAt"`
+	UsageCount int64      `json:"usageCount"` // 签名验证通过的次数
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// IsActive 检查密钥在指定时间是否有效（未吊销且未过期）
+func (s *AppSecret) IsActive(now time.Time) bool {
+	if s.RevokedAt != nil {
+		return false
+	}
+	return s.ExpiresAt == nil || now.Before(*s.ExpiresAt)
+}
+
+// ListAppSecrets 获取全部密钥
+func ListAppSecrets(db *gorm.DB) ([]AppSecret, error) {
+	var secrets []AppSecret
+	err := db.Order("id ASC").Find(&secrets).Error
+	return secrets, err
+}
+
+// AddAppSecretUsage 累加密钥使用次数并更新最后使用时间
+func AddAppSecretUsage(db *gorm.DB, id uint, count int64, lastUsed time.Time) error {
+	return db.Model(&AppSecret{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"usage_count":  gorm.Expr("usage_count + ?", count),
+		"last_used_at": lastUsed,
+	}).Error
+}
+
+// migrateLegacyAppSecrets 将旧版 apps.app_secret 列中的密钥迁移到 app_secrets 表
+func migrateLegacyAppSecrets(db *gorm.DB) error {
+	if !db.Migrator().HasColumn("apps", "app_secret") {
+		return nil
+	}
+
+	var legacy []struct {
+		AppID     string
+		AppSecret string
+	}
+	if err := db.Table("apps").Select("app_id, app_secret").Where("app_secret <> ''").Scan(&legacy).Error; err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, app := range legacy {
+			var count int64
+			if err := tx.Model(&AppSecret{}).Where("app_id = ?", app.AppID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				continue
+			}
+			if err := tx.Create(&AppSecret{AppID: app.AppID, Name: "default", Secret: app.AppSecret}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Exec("ALTER TABLE apps DROP COLUMN app_secret").Error
+	})
+}
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 上报类型（配额和用量按类型分别统计）
+const (
+	UsageKindError = "error"
+	UsageKindEvent = "event"
+)
+
+// UsageDayLayout 用量统计的日期格式（UTC）
+const UsageDayLayout = "2006-01-02"
+
+// AppUsage 应用每日上报用量
+type AppUsage struct {
+	ID           uint   `gorm:"primaryKey" json:"-"`
+	AppID        string `gorm:"uniqueIndex:idx_app_usage_day" json:"appId"`
+	Day          string `gorm:"uniqueIndex:idx_app_usage_day" json:"day"` // UTC 日期，如 2006-01-02
+	Kind         string `gorm:"uniqueIndex:idx_app_usage_day" json:"kind"`
+	Accepted     int64  `json:"accepted"`     // 已接收
+	QuotaDropped int64  `json:"quotaDropped"` // 超出配额被拒绝
+	SpikeDropped int64  `json:"spikeDropped"` // 突增保护采样丢弃
+}
+
+// UsageDay 时间对应的用量统计日期
+func UsageDay(t time.Time) string {
+	return t.UTC().Format(UsageDayLayout)
+}
+
+// AddAppUsage 累加应用某天的用量
+func AddAppUsage(db *gorm.DB, u AppUsage) error {
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "app_id"}, {Name: "day"}, {Name: "kind"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"accepted":      gorm.Expr("app_usages.accepted + excluded.accepted"),
+			"quota_dropped": gorm.Expr("app_usages.quota_dropped + excluded.quota_dropped"),
+			"spike_dropped": gorm.Expr("app_usages.spike_dropped + excluded.spike_dropped"),
+		}),
+	}).Create(&u).Error
+}
+
+// ListAppUsage 获取应用从 fromDay（含）开始的每日用量
+func ListAppUsage(db *gorm.DB, appID, fromDay string) ([]AppUsage, error) {
+	var list []AppUsage
+	err := db.Where("app_id = ? AND day >= ?", appID, fromDay).Order("day ASC, kind ASC").Find(&list).Error
+	return list, err
+}
+
+// SumAppUsage 按应用和类型汇总从 fromDay（含）开始的用量
+func SumAppUsage(db *gorm.DB, fromDay string) ([]AppUsage, error) {
+	var list []AppUsage
+	err := db.Model(&AppUsage{}).
+		Select("app_id, kind, SUM(accepted) AS accepted, SUM(quota_dropped) AS quota_dropped, SUM(spike_dropped) AS spike_dropped").
+		Where("day >= ?", fromDay).
+		Group("app_id, kind").
+		Scan(&list).Error
+	return list, err
+}
@@ -0,0 +1,104 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 审计日志操作类型
+const (
+	AuditLoginSuccess = "login.success"
+	AuditLoginFailure = "login.failure"
+	AuditLogout       = "logout"
+
+	AuditTokenCreate = "token.create"
+	AuditTokenRevoke = "token.revoke"
+
+	AuditTOTPEnable        = "totp.enable"
+	AuditTOTPDisable       = "totp.disable"
+	AuditTOTPRecoveryCodes = "totp.recovery_codes"
+
+	AuditAppCreate       = "app.create"
+	AuditAppUpdate       = "app.update"
+	AuditAppDelete       = "app.delete"
+	AuditAppSecretCreate = "app_secret.create"
+	AuditAppSecretUpdate = "app_secret.update"
+	AuditAppSecretRevoke = "app_secret.revoke"
+
+	AuditUserCreate         = "user.create"
+	AuditUserUpdate         = "user.update"
+	AuditUserDelete         = "user.delete"
+	AuditUserRevokeSessions = "user.revoke_sessions"
+	AuditUserResetTOTP      = "user.reset_totp"
+
+	AuditEventCreate = "event.create"
+	AuditEventUpdate = "event.update"
+	AuditEventDelete = "event.delete"
+
+	AuditPendingEventApprove = "pending_event.approve"
+	AuditPendingEventDismiss = "pending_event.dismiss"
+
+	// 查看原始上报数据（可能包含终端用户的个人信息）
+	AuditDataUserTimeline = "data.user_timeline"
+	AuditDataEventList    = "data.event_list"
+	AuditDataErrorList    = "data.error_list"
+)
+
+// AuditLog 审计日志（登录、配置变更等操作记录）
+type AuditLog struct {
+	ID        uint                   `gorm:"primaryKey" json:"id"`
+	Username  string                 `gorm:"index" json:"username"` // 操作人，登录失败时为尝试登录的用户名
+	Action    string                 `gorm:"index" json:"action"`   // 操作类型，如 login.success、app.update
+	Target    string                 `json:"target"`                // 操作对象，如 AppID、用户名
+	Success   bool                   `json:"success"`
+	IP        string                 `json:"ip"`
+	UserAgent string                 `json:"userAgent"`
+	Details   map[string]interface{} `gorm:"serializer:json" json:"details,omitempty"`
+	CreatedAt time.Time              `gorm:"index" json:"createdAt"`
+}
+
+// AuditFilter 审计日志查询条件（空值表示不限）
+type AuditFilter struct {
+	Username string
+	Action   string // 精确匹配，以 . 结尾时按前缀匹配（如 app.）
+	Target   string
+	Success  *bool
+	Since    time.Time
+	Until    time.Time
+}
+
+// ListAuditLogs 按条件分页查询审计日志（按时间倒序），返回列表和总数
+func ListAuditLogs(db *gorm.DB, f AuditFilter, offset, limit int) ([]AuditLog, int64, error) {
+	query := db.Model(&AuditLog{})
+	if f.Username != "" {
+		query = query.Where("username = ?", f.Username)
+	}
+	if f.Action != "" {
+		if f.Action[len(f.Action)-1] == '.' {
+			query = query.Where("action LIKE ?", f.Action+"%")
+		} else {
+			query = query.Where("action = ?", f.Action)
+		}
+	}
+	if f.Target != "" {
+		query = query.Where("target = ?", f.Target)
+	}
+	if f.Success != nil {
+		query = query.Where("success = ?", *f.Success)
+	}
+	if !f.Since.IsZero() {
+		query = query.Where("created_at >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		query = query.Where("created_at < ?", f.Until)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var logs []AuditLog
+	err := query.Order("id DESC").Offset(offset).Limit(limit).Find(&logs).Error
+	return logs, total, err
+}
@@ -1,10 +1,12 @@
 package model
 
 import (
+	"database/sql"
 	"fmt"
 	"sync"
 
 	"github.com/glebarez/sqlite"
+	"github.com/hanxi/tracely/internal/metrics"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
@@ -14,6 +16,13 @@ var (
 	dbOnce     sync.Once
 )
 
+// migratedModels 自动迁移的数据表（/readyz 据此检查迁移是否完成）
+var migratedModels = []interface{}{
+	&ErrorLog{}, &ErrorOccurrence{}, &Event{},
+	&App{}, &AppSecret{}, &User{}, &APIToken{}, &Session{}, &RevokedToken{}, &EventDefinition{}, &AuditLog{},
+	&AppUsage{}, &EventHourlyRollup{}, &EventDailyRollup{}, &EventUserRollup{}, &RollupState{}, &PendingEvent{},
+}
+
 // InitDB 初始化数据库（SQLite + 性能优化）
 func InitDB(path string) (*gorm.DB, error) {
 	var err error
@@ -26,8 +35,9 @@ func InitDB(path string) (*gorm.DB, error) {
 			return
 		}
 
-		// 获取底层 sql.DB
-		sqlDB, err := dbInstance.DB()
+		// 获取底层 sql.DB（不能用 := 遮蔽外层 err，否则后续迁移失败时 InitDB 仍返回 nil）
+		var sqlDB *sql.DB
+		sqlDB, err = dbInstance.DB()
 		if err != nil {
 			err = fmt.Errorf("failed to get underlying sql.DB: %w", err)
 			return
@@ -47,12 +57,26 @@ func InitDB(path string) (*gorm.DB, error) {
 		sqlDB.SetMaxOpenConns(1)
 		sqlDB.SetMaxIdleConns(1)
 
+		// 记录数据库操作耗时（/metrics）
+		if err = metrics.InstrumentDB(dbInstance); err != nil {
+			err = fmt.Errorf("failed to instrument database: %w", err)
+			return
+		}
+
 		// 自动迁移数据表
-		err = dbInstance.AutoMigrate(&ErrorLog{}, &Event{})
+		err = dbInstance.AutoMigrate(migratedModels...)
 		if err != nil {
 			err = fmt.Errorf("failed to auto migrate: %w", err)
 			return
 		}
+		if err = migrateLegacyAppSecrets(dbInstance); err != nil {
+			err = fmt.Errorf("failed to migrate app secrets: %w", err)
+			return
+		}
+		if err = migrateErrorLogTimes(dbInstance); err != nil {
+			err = fmt.Errorf("failed to migrate error log times: %w", err)
+			return
+		}
 
 		fmt.Printf("[Tracely] Database initialized: %s\n", path)
 	})
@@ -60,6 +84,21 @@ func InitDB(path string) (*gorm.DB, error) {
 	return dbInstance, err
 }
 
+// CheckMigrations 检查所有数据表是否已创建，返回缺失的表名
+func CheckMigrations(db *gorm.DB) ([]string, error) {
+	var missing []string
+	for _, m := range migratedModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(m); err != nil {
+			return nil, err
+		}
+		if !db.Migrator().HasTable(m) {
+			missing = append(missing, stmt.Schema.Table)
+		}
+	}
+	return missing, nil
+}
+
 // GetDB 获取数据库实例
 func GetDB() *gorm.DB {
 	return dbInstance
@@ -4,6 +4,9 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // ErrorLog 错误日志
@@ -21,6 +24,16 @@ type ErrorLog struct {
 	LastSeen    time.Time `gorm:"index"` // 按最近出现排序
 }
 
+// ErrorOccurrence 错误发生记录（上报携带用户 ID 时记录，用于还原用户时间线）
+type ErrorOccurrence struct {
+	ID          uint      `gorm:"primaryKey"`
+	Fingerprint string    `gorm:"index"`                          // 关联 ErrorLog
+	AppID       string    `gorm:"index:idx_occurrence_user_time"` // 应用 ID
+	UserID      string    `gorm:"index:idx_occurrence_user_time"` // 用户 ID
+	URL         string    // 发生页面
+	CreatedAt   time.Time `gorm:"index:idx_occurrence_user_time"` // 发生时间
+}
+
 // GenFingerprint 生成错误指纹
 // 规则：MD5(appId + type + message)
 func GenFingerprint(appID, errType, message string) string {
@@ -28,3 +41,94 @@ func GenFingerprint(appID, errType, message string) string {
 	hash := md5.Sum([]byte(raw))
 	return hex.EncodeToString(hash[:])
 }
+
+// ErrorReport 一次错误上报（ReportedAt 为接收时间，异步写入时以此为准）
+type ErrorReport struct {
+	Type       string
+	Message    string
+	Stack      string
+	URL        string
+	AppID      string
+	UserID     string
+	UserAgent  string
+	ReportedAt time.Time
+}
+
+// SaveErrorReport 写入错误上报：相同指纹原子地累加次数（INSERT ... ON CONFLICT DO UPDATE），否则新建；
+// 携带用户 ID 时记录发生明细。并发上报不会丢失计数（error_log_test.go 在 SQLite 上验证）
+//
+// 上报可能乱序写入，首次/最近出现时间在 SQL 中比较，堆栈和 URL 只在上报不早于当前最近出现时间时更新。
+// SQLite 按文本比较时间，因此统一存为 UTC 并截断到秒（RFC3339 文本长度固定，文本顺序即时间顺序）
+func SaveErrorReport(tx *gorm.DB, r ErrorReport) error {
+	fingerprint := GenFingerprint(r.AppID, r.Type, r.Message)
+	seen := errorSeenTime(r.ReportedAt)
+
+	newLog := ErrorLog{
+		Fingerprint: fingerprint,
+		Type:        r.Type,
+		Message:     r.Message,
+		Stack:       r.Stack,
+		URL:         r.URL,
+		AppID:       r.AppID,
+		UserAgent:   r.UserAgent,
+		Count:       1,
+		FirstSeen:   seen,
+		LastSeen:    seen,
+	}
+	err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "fingerprint"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"count": gorm.Expr("error_logs.count + excluded.count"),
+			// 首次/最近出现时间分别取较早/较晚的一个，堆栈和 URL 保留最近一次上报的样本
+			// （SET 中的 error_logs.last_seen 都是更新前的值）
+			"first_seen": gorm.Expr("CASE WHEN excluded.first_seen < error_logs.first_seen THEN excluded.first_seen ELSE error_logs.first_seen END"),
+			"last_seen":  gorm.Expr("CASE WHEN excluded.last_seen > error_logs.last_seen THEN excluded.last_seen ELSE error_logs.last_seen END"),
+			"stack":      gorm.Expr("CASE WHEN excluded.last_seen >= error_logs.last_seen THEN excluded.stack ELSE error_logs.stack END"),
+			"url":        gorm.Expr("CASE WHEN excluded.last_seen >= error_logs.last_seen THEN excluded.url ELSE error_logs.url END"),
+		}),
+	}).Create(&newLog).Error
+	if err != nil {
+		return err
+	}
+
+	if r.UserID == "" {
+		return nil
+	}
+	occurrence := ErrorOccurrence{
+		Fingerprint: fingerprint,
+		AppID:       r.AppID,
+		UserID:      r.UserID,
+		URL:         r.URL,
+		CreatedAt:   r.ReportedAt.UTC(),
+	}
+	return tx.Create(&occurrence).Error
+}
+
+// errorSeenTime 错误首次/最近出现时间的存储形式：UTC 并截断到秒
+func errorSeenTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
+
+// migrateErrorLogTimes 将旧版按服务器本地时区写入的首次/最近出现时间转换为 UTC，
+// 否则与新写入的 UTC 时间按文本比较时顺序错误
+func migrateErrorLogTimes(db *gorm.DB) error {
+	var logs []ErrorLog
+	err := db.Select("id, first_seen, last_seen").
+		Where("first_seen NOT LIKE ? OR last_seen NOT LIKE ?", "%Z", "%Z").
+		Find(&logs).Error
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, l := range logs {
+			err := tx.Model(&ErrorLog{}).Where("id = ?", l.ID).UpdateColumns(map[string]interface{}{
+				"first_seen": errorSeenTime(l.FirstSeen),
+				"last_seen":  errorSeenTime(l.LastSeen),
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,147 @@
+package model
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB 在临时目录创建 SQLite 数据库（多连接 + WAL，写入冲突时等待而不是报错）
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "tracely.db") + "?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	if err := db.AutoMigrate(&ErrorLog{}, &ErrorOccurrence{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("sql db: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(8)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+func TestSaveErrorReportConcurrent(t *testing.T) {
+	db := openTestDB(t)
+
+	const n = 50
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- db.Transaction(func(tx *gorm.DB) error {
+				return SaveErrorReport(tx, ErrorReport{
+					Type:       "TypeError",
+					Message:    "x is undefined",
+					AppID:      "app",
+					UserID:     "u1",
+					ReportedAt: base.Add(time.Duration(i) * time.Second),
+				})
+			})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("SaveErrorReport: %v", err)
+		}
+	}
+
+	var logs []ErrorLog
+	if err := db.Find(&logs).Error; err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	if len(logs) != 1 {
+		t.Fatalf("got %d error logs, want 1", len(logs))
+	}
+	if logs[0].Count != n {
+		t.Errorf("count = %d, want %d", logs[0].Count, n)
+	}
+	if !logs[0].FirstSeen.Equal(base) {
+		t.Errorf("first_seen = %v, want %v", logs[0].FirstSeen, base)
+	}
+	if want := base.Add((n - 1) * time.Second); !logs[0].LastSeen.Equal(want) {
+		t.Errorf("last_seen = %v, want %v", logs[0].LastSeen, want)
+	}
+
+	var occurrences int64
+	if err := db.Model(&ErrorOccurrence{}).Count(&occurrences).Error; err != nil {
+		t.Fatalf("count occurrences: %v", err)
+	}
+	if occurrences != n {
+		t.Errorf("occurrences = %d, want %d", occurrences, n)
+	}
+}
+
+func TestSaveErrorReportOutOfOrder(t *testing.T) {
+	db := openTestDB(t)
+
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	east := time.FixedZone("UTC+8", 8*60*60)
+	reports := []ErrorReport{
+		{Stack: "middle", URL: "/middle", ReportedAt: base.Add(time.Minute)},
+		// 本地时区的文本（20:02+08:00）比 UTC 的文本（12:01Z）大，但实际时间更早
+		{Stack: "oldest", URL: "/oldest", ReportedAt: base.In(east)},
+		{Stack: "newest", URL: "/newest", ReportedAt: base.Add(2*time.Minute + 500*time.Millisecond)},
+		{Stack: "late", URL: "/late", ReportedAt: base.Add(90 * time.Second).In(east)},
+	}
+	for _, r := range reports {
+		r.Type, r.Message, r.AppID = "TypeError", "x is undefined", "app"
+		if err := SaveErrorReport(db, r); err != nil {
+			t.Fatalf("SaveErrorReport: %v", err)
+		}
+	}
+
+	var log ErrorLog
+	if err := db.First(&log).Error; err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	if log.Count != len(reports) {
+		t.Errorf("count = %d, want %d", log.Count, len(reports))
+	}
+	if !log.FirstSeen.Equal(base) {
+		t.Errorf("first_seen = %v, want %v", log.FirstSeen, base)
+	}
+	if want := base.Add(2 * time.Minute); !log.LastSeen.Equal(want) {
+		t.Errorf("last_seen = %v, want %v", log.LastSeen, want)
+	}
+	if log.Stack != "newest" || log.URL != "/newest" {
+		t.Errorf("sample = %q %q, want the newest report", log.Stack, log.URL)
+	}
+}
+
+func TestMigrateErrorLogTimes(t *testing.T) {
+	db := openTestDB(t)
+
+	east := time.FixedZone("UTC+8", 8*60*60)
+	seen := time.Date(2026, 1, 1, 20, 0, 0, 250, east)
+	if err := db.Create(&ErrorLog{Fingerprint: "f", FirstSeen: seen, LastSeen: seen}).Error; err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := migrateErrorLogTimes(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	var raw struct{ FirstSeen, LastSeen string }
+	if err := db.Raw("SELECT first_seen, last_seen FROM error_logs").Scan(&raw).Error; err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	want := "2026-01-01T12:00:00Z"
+	if raw.FirstSeen != want || raw.LastSeen != want {
+		t.Errorf("stored times = %q %q, want %q", raw.FirstSeen, raw.LastSeen, want)
+	}
+}
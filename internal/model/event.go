@@ -2,6 +2,7 @@ package model
 
 import (
 	"encoding/json"
+	"sort"
 	"time"
 
 	"gorm.io/gorm"
@@ -15,34 +16,65 @@ const (
 // Event 统一事件模型
 type Event struct {
 	ID        uint            `gorm:"primaryKey"`
-	EventName string          `gorm:"index:idx_event_name;index:idx_app_event_time"` // 事件名称
-	Metadata  json.RawMessage `gorm:"type:text"`                                     // 元数据（JSON 格式）
-	AppID     string          `gorm:"index:idx_app_event_time"`                      // 应用 ID
-	UserID    string          `gorm:"index"`                                         // 用户 ID
-	CreatedAt time.Time       `gorm:"index:idx_app_event_time"`                      // 创建时间
+	EventName string          `gorm:"index:idx_event_name;index:idx_app_event_time"`          // 事件名称
+	Metadata  json.RawMessage `gorm:"type:text"`                                              // 元数据（JSON 格式）
+	AppID     string          `gorm:"index:idx_app_event_time;index:idx_app_user_time"`       // 应用 ID
+	UserID    string          `gorm:"index;index:idx_app_user_time"`                          // 用户 ID（idx_app_user_time 用于路径分析按用户加载事件）
+	CreatedAt time.Time       `gorm:"index;index:idx_app_event_time;index:idx_app_user_time"` // 创建时间
 }
 
-// CreateEvent 创建事件记录
-func CreateEvent(db *gorm.DB, eventName string, metadata map[string]interface{}, appID, userID string) error {
+// NewEvent 构造事件记录（创建时间为当前时间）
+func NewEvent(eventName string, metadata map[string]interface{}, appID, userID string) (*Event, error) {
 	// 将 metadata 转换为 JSON
 	var metadataJSON json.RawMessage
 	if metadata != nil {
 		data, err := json.Marshal(metadata)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		metadataJSON = data
 	}
 
-	event := Event{
+	return &Event{
 		EventName: eventName,
 		Metadata:  metadataJSON,
 		AppID:     appID,
 		UserID:    userID,
 		CreatedAt: time.Now(),
+	}, nil
+}
+
+// CreateEvent 创建事件记录
+func CreateEvent(db *gorm.DB, eventName string, metadata map[string]interface{}, appID, userID string) error {
+	event, err := NewEvent(eventName, metadata, appID, userID)
+	if err != nil {
+		return err
 	}
+	return db.Create(event).Error
+}
+
+// ListEventNames 获取原始事件中出现过的全部事件名称
+func ListEventNames(db *gorm.DB) ([]string, error) {
+	var names []string
+	err := db.Model(&Event{}).Distinct("event_name").Pluck("event_name", &names).Error
+	return names, err
+}
 
-	return db.Create(&event).Error
+// DeleteEventsBefore 分批删除某事件早于 before 的原始事件（每批最多 batch 行，避免长时间占用写锁），返回删除的行数
+func DeleteEventsBefore(db *gorm.DB, eventName string, before time.Time, batch int) (int64, error) {
+	var total int64
+	for {
+		ids := db.Model(&Event{}).Select("id").
+			Where("event_name = ? AND created_at < ?", eventName, before).Limit(batch)
+		result := db.Where("id IN (?)", ids).Delete(&Event{})
+		if result.Error != nil {
+			return total, result.Error
+		}
+		total += result.RowsAffected
+		if result.RowsAffected < int64(batch) {
+			return total, nil
+		}
+	}
 }
 
 // EventStats 事件统计结果
@@ -53,23 +85,28 @@ type EventStats struct {
 
 // GetEventStats 获取事件统计（按事件名称分组）
 func GetEventStats(db *gorm.DB, appID string, eventName string, days int) ([]EventStats, error) {
-	since := time.Now().AddDate(0, 0, -days)
-
-	query := db.Model(&Event{}).
-		Select("event_name, COUNT(*) as count").
-		Where("created_at >= ?", since)
+	now := time.Now()
+	return GetEventStatsBetween(db, appID, eventName, now.AddDate(0, 0, -days), now)
+}
 
-	if appID != "" {
-		query = query.Where("app_id = ?", appID)
+// GetEventStatsBetween 获取 [from, to) 内的事件统计（按事件名称分组，次数倒序）
+func GetEventStatsBetween(db *gorm.DB, appID string, eventName string, from, to time.Time) ([]EventStats, error) {
+	counts, err := countByEventNameBetween(db, appID, eventName, from, to)
+	if err != nil {
+		return nil, err
 	}
 
-	if eventName != "" {
-		query = query.Where("event_name = ?", eventName)
+	stats := make([]EventStats, 0, len(counts))
+	for name, count := range counts {
+		stats = append(stats, EventStats{EventName: name, Count: count})
 	}
-
-	var stats []EventStats
-	err := query.Group("event_name").Order("count DESC").Scan(&stats).Error
-	return stats, err
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Count != stats[j].Count {
+			return stats[i].Count > stats[j].Count
+		}
+		return stats[i].EventName < stats[j].EventName
+	})
+	return stats, nil
 }
 
 // TopEvent Top 事件结果
@@ -80,19 +117,20 @@ type TopEvent struct {
 
 // GetTopEvents 获取 Top 事件排行
 func GetTopEvents(db *gorm.DB, appID string, days int, limit int) ([]TopEvent, error) {
-	since := time.Now().AddDate(0, 0, -days)
-
-	query := db.Model(&Event{}).
-		Select("event_name, COUNT(*) as count").
-		Where("created_at >= ?", since)
-
-	if appID != "" {
-		query = query.Where("app_id = ?", appID)
+	now := time.Now()
+	stats, err := GetEventStatsBetween(db, appID, "", now.AddDate(0, 0, -days), now)
+	if err != nil {
+		return nil, err
 	}
 
-	var events []TopEvent
-	err := query.Group("event_name").Order("count DESC").Limit(limit).Scan(&events).Error
-	return events, err
+	if len(stats) > limit {
+		stats = stats[:limit]
+	}
+	events := make([]TopEvent, 0, len(stats))
+	for _, s := range stats {
+		events = append(events, TopEvent(s))
+	}
+	return events, nil
 }
 
 // DailyEvent 每日事件统计
@@ -103,59 +141,35 @@ type DailyEvent struct {
 
 // GetDailyEvents 获取每日事件统计
 func GetDailyEvents(db *gorm.DB, appID string, eventName string, days int) ([]DailyEvent, error) {
-	since := time.Now().AddDate(0, 0, -days)
-
-	query := db.Model(&Event{}).
-		Select("DATE(created_at) as date, COUNT(*) as count").
-		Where("created_at >= ?", since)
+	now := time.Now()
+	return GetDailyEventsBetween(db, appID, eventName, now.AddDate(0, 0, -days), now)
+}
 
-	if appID != "" {
-		query = query.Where("app_id = ?", appID)
+// GetDailyEventsBetween 获取 [from, to) 内的每日事件统计（按日期升序，只返回有数据的日期）
+func GetDailyEventsBetween(db *gorm.DB, appID string, eventName string, from, to time.Time) ([]DailyEvent, error) {
+	counts, err := dailyCountsBetween(db, appID, eventName, from, to)
+	if err != nil {
+		return nil, err
 	}
 
-	if eventName != "" {
-		query = query.Where("event_name = ?", eventName)
+	daily := make([]DailyEvent, 0, len(counts))
+	for date, count := range counts {
+		if count > 0 {
+			daily = append(daily, DailyEvent{Date: date, Count: count})
+		}
 	}
-
-	var daily []DailyEvent
-	err := query.Group("DATE(created_at)").Order("date ASC").Scan(&daily).Error
-	return daily, err
+	sort.Slice(daily, func(i, j int) bool { return daily[i].Date < daily[j].Date })
+	return daily, nil
 }
 
 // GetEventCount 获取事件总数
 func GetEventCount(db *gorm.DB, appID string, eventName string, since time.Time) (int64, error) {
-	query := db.Model(&Event{}).Where("created_at >= ?", since)
-
-	if appID != "" {
-		query = query.Where("app_id = ?", appID)
-	}
-
-	if eventName != "" {
-		query = query.Where("event_name = ?", eventName)
-	}
-
-	var count int64
-	err := query.Count(&count).Error
-	return count, err
+	return CountEventsBetween(db, appID, eventName, since, time.Now())
 }
 
 // GetUniqueUserCount 获取唯一用户数（UV）
 func GetUniqueUserCount(db *gorm.DB, appID string, eventName string, since time.Time) (int64, error) {
-	query := db.Model(&Event{}).
-		Select("COUNT(DISTINCT user_id)").
-		Where("created_at >= ?", since)
-
-	if appID != "" {
-		query = query.Where("app_id = ?", appID)
-	}
-
-	if eventName != "" {
-		query = query.Where("event_name = ?", eventName)
-	}
-
-	var count int64
-	err := query.Scan(&count).Error
-	return count, err
+	return CountUniqueUsersBetween(db, appID, eventName, since, time.Now())
 }
 
 // EventDetail 事件详情
@@ -215,23 +229,26 @@ type EventStatsSummary struct {
 
 // GetEventStatsSummary 获取事件统计摘要
 func GetEventStatsSummary(db *gorm.DB, appID string, eventName string, days int) (*EventStatsSummary, error) {
-	since := time.Now().AddDate(0, 0, -days)
-	today := time.Now().Truncate(24 * time.Hour)
+	now := time.Now()
+	return GetEventStatsSummaryBetween(db, appID, eventName, now.AddDate(0, 0, -days), now.Truncate(24*time.Hour), now)
+}
 
+// GetEventStatsSummaryBetween 获取 [from, to) 内的事件统计摘要，今日次数按 [dayStart, to) 统计
+func GetEventStatsSummaryBetween(db *gorm.DB, appID string, eventName string, from, dayStart, to time.Time) (*EventStatsSummary, error) {
 	// 获取总次数
-	totalCount, err := GetEventCount(db, appID, eventName, since)
+	totalCount, err := CountEventsBetween(db, appID, eventName, from, to)
 	if err != nil {
 		return nil, err
 	}
 
 	// 获取今日次数
-	todayCount, err := GetEventCount(db, appID, eventName, today)
+	todayCount, err := CountEventsBetween(db, appID, eventName, dayStart, to)
 	if err != nil {
 		return nil, err
 	}
 
 	// 获取 UV
-	uv, err := GetUniqueUserCount(db, appID, eventName, since)
+	uv, err := CountUniqueUsersBetween(db, appID, eventName, from, to)
 	if err != nil {
 		return nil, err
 	}
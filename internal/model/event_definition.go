@@ -0,0 +1,172 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// EventDefinition 事件定义（白名单）
+type EventDefinition struct {
+	ID                 uint            `gorm:"primaryKey" json:"-"`
+	EventName          string          `gorm:"uniqueIndex" json:"eventName"` // 事件名称，Match 为 glob / regex 时为匹配模式
+	Match              string          `gorm:"default:exact" json:"match"`   // 匹配方式：exact / glob / regex
+	Description        string          `json:"description"`
+	RetentionDays      int             `json:"retentionDays"`                 // 数据保留天数（0=永久保留）
+	Schema             *MetadataSchema `gorm:"serializer:json" json:"schema"` // metadata 结构约束，为空表示不校验
+	ValidationFailures int64           `json:"validationFailures"`            // metadata 校验失败次数
+	LastValidationErr  string          `json:"lastValidationError"`           // 最近一次校验失败原因
+	LastValidationAt   *time.Time      `json:"lastValidationAt"`              // 最近一次校验失败时间
+	CreatedAt          time.Time       `json:"createdAt"`
+	UpdatedAt          time.Time       `json:"updatedAt"`
+}
+
+// 事件名称匹配方式
+const (
+	EventMatchExact = "exact" // 完全相同
+	EventMatchGlob  = "glob"  // 通配符，* 匹配任意个字符，? 匹配单个字符，如 checkout_*
+	EventMatchRegex = "regex" // 正则表达式（匹配整个事件名称）
+)
+
+// CompilePattern 编译 glob / regex 事件的匹配模式，exact 事件返回 nil
+func (d *EventDefinition) CompilePattern() (*regexp.Regexp, error) {
+	switch d.Match {
+	case "", EventMatchExact:
+		return nil, nil
+	case EventMatchGlob:
+		expr := regexp.QuoteMeta(d.EventName)
+		expr = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(expr)
+		return regexp.Compile("^" + expr + "$")
+	case EventMatchRegex:
+		return regexp.Compile("^(?:" + d.EventName + ")$")
+	}
+	return nil, fmt.Errorf("invalid match %q", d.Match)
+}
+
+// metadata 校验失败时的处理方式
+const (
+	SchemaModeReject = "reject" // 拒绝上报
+	SchemaModeStrip  = "strip"  // 去掉未声明和类型不符的属性后接收
+	SchemaModeWarn   = "warn"   // 原样接收，只记录失败次数
+)
+
+// metadata 属性类型（JSON 类型）
+const (
+	PropertyString  = "string"
+	PropertyNumber  = "number"
+	PropertyInteger = "integer"
+	PropertyBoolean = "boolean"
+	PropertyObject  = "object"
+	PropertyArray   = "array"
+	PropertyAny     = "any"
+)
+
+// MetadataSchema 事件 metadata 结构约束（属性名 -> 类型）
+type MetadataSchema struct {
+	Properties           map[string]string `json:"properties"`
+	Required             []string          `json:"required,omitempty"`
+	AdditionalProperties bool              `json:"additionalProperties"` // 是否允许未声明的属性
+	Mode                 string            `json:"mode"`                 // reject / strip / warn，默认 reject
+}
+
+// Check 检查约束本身是否合法
+func (s *MetadataSchema) Check() error {
+	switch s.Mode {
+	case "", SchemaModeReject, SchemaModeStrip, SchemaModeWarn:
+	default:
+		return fmt.Errorf("invalid mode %q", s.Mode)
+	}
+	for name, typ := range s.Properties {
+		switch typ {
+		case PropertyString, PropertyNumber, PropertyInteger, PropertyBoolean, PropertyObject, PropertyArray, PropertyAny:
+		default:
+			return fmt.Errorf("invalid type %q for property %s", typ, name)
+		}
+	}
+	for _, name := range s.Required {
+		if _, ok := s.Properties[name]; !ok {
+			return fmt.Errorf("required property %s is not declared", name)
+		}
+	}
+	return nil
+}
+
+// GetMode 校验失败时的处理方式
+func (s *MetadataSchema) GetMode() string {
+	if s.Mode == "" {
+		return SchemaModeReject
+	}
+	return s.Mode
+}
+
+// Validate 校验 metadata，返回失败原因（按属性名排序）和去掉未声明、类型不符属性后的 metadata
+func (s *MetadataSchema) Validate(metadata map[string]interface{}) ([]string, map[string]interface{}) {
+	var problems []string
+	stripped := make(map[string]interface{}, len(metadata))
+	for name, value := range metadata {
+		typ, ok := s.Properties[name]
+		switch {
+		case !ok && !s.AdditionalProperties:
+			problems = append(problems, fmt.Sprintf("未声明的属性 %s", name))
+		case ok && !matchType(typ, value):
+			problems = append(problems, fmt.Sprintf("属性 %s 应为 %s", name, typ))
+		default:
+			stripped[name] = value
+		}
+	}
+	for _, name := range s.Required {
+		if _, ok := metadata[name]; !ok {
+			problems = append(problems, fmt.Sprintf("缺少必填属性 %s", name))
+		}
+	}
+	sort.Strings(problems)
+	return problems, stripped
+}
+
+// matchType 检查 JSON 解码后的值是否符合类型
+func matchType(typ string, value interface{}) bool {
+	switch typ {
+	case PropertyAny:
+		return true
+	case PropertyString:
+		_, ok := value.(string)
+		return ok
+	case PropertyNumber:
+		_, ok := value.(float64)
+		return ok
+	case PropertyInteger:
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case PropertyBoolean:
+		_, ok := value.(bool)
+		return ok
+	case PropertyObject:
+		_, ok := value.(map[string]interface{})
+		return ok
+	case PropertyArray:
+		_, ok := value.([]interface{})
+		return ok
+	}
+	return false
+}
+
+// ListEventDefinitions 获取全部事件定义
+func ListEventDefinitions(db *gorm.DB) ([]EventDefinition, error) {
+	var events []EventDefinition
+	err := db.Order("id ASC").Find(&events).Error
+	return events, err
+}
+
+// AddEventValidationFailures 累加事件的 metadata 校验失败次数
+func AddEventValidationFailures(db *gorm.DB, eventName string, count int64, lastErr string, lastAt time.Time) error {
+	return db.Model(&EventDefinition{}).Where("event_name = ?", eventName).UpdateColumns(map[string]interface{}{
+		"validation_failures": gorm.Expr("validation_failures + ?", count),
+		"last_validation_err": lastErr,
+		"last_validation_at":  lastAt,
+	}).Error
+}
@@ -0,0 +1,285 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 路径分析的方向和特殊节点
+const (
+	PathDirectionAfter  = "after"  // 起始事件之后
+	PathDirectionBefore = "before" // 起始事件之前
+
+	PathNodeEnd   = "(end)"   // 会话在达到深度前结束
+	PathNodeOther = "(other)" // 被裁剪的低频分支
+)
+
+// PathQuery 路径分析参数
+type PathQuery struct {
+	AppID         string
+	StartEvent    string
+	Direction     string        // after 或 before
+	MaxDepth      int           // 最大步数
+	SessionGap    time.Duration // 会话切分的不活跃间隔
+	From          time.Time
+	To            time.Time
+	TopN          int      // 每个节点保留的最大分支数，其余合并为 (other)
+	ExcludeEvents []string // 不参与路径的事件（如 _active 心跳）
+	MaxUsers      int      // 最多抽样的用户数（只抽取触发过起始事件的用户）
+	MaxEvents     int      // 最多扫描的事件数，防止大范围查询拖垮数据库
+}
+
+// pathUserBatch 按用户加载事件时每次查询的用户数
+// 用户按随机顺序分批加载，批内按 user_id 排序，达到事件数上限时只有最后一批按 user_id 截断
+const pathUserBatch = 100
+
+// PathNode 路径树节点
+type PathNode struct {
+	ID       string      `json:"id"` // 深度:事件名，Sankey 中全局唯一
+	Name     string      `json:"name"`
+	Depth    int         `json:"depth"`
+	Count    int64       `json:"count"`
+	Children []*PathNode `json:"children,omitempty"`
+
+	index map[string]*PathNode
+}
+
+// PathLink Sankey 连线
+type PathLink struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Value  int64  `json:"value"`
+}
+
+// SankeyNode Sankey 节点
+type SankeyNode struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Depth int    `json:"depth"`
+}
+
+// PathResult 路径分析结果（同时提供树形和 Sankey 两种结构）
+type PathResult struct {
+	StartEvent string       `json:"startEvent"`
+	Direction  string       `json:"direction"`
+	Sessions   int64        `json:"sessions"`  // 包含起始事件的会话数
+	Truncated  bool         `json:"truncated"` // 用户数或扫描事件数达到上限，结果基于随机抽样的部分用户
+	Tree       *PathNode    `json:"tree"`
+	Nodes      []SankeyNode `json:"nodes"`
+	Links      []PathLink   `json:"links"`
+}
+
+// pathEvent 路径分析扫描的事件
+type pathEvent struct {
+	UserID    string
+	EventName string
+	CreatedAt time.Time
+}
+
+// child 获取或创建子节点
+func (n *PathNode) child(name string) *PathNode {
+	if n.index == nil {
+		n.index = make(map[string]*PathNode)
+	}
+	if c, ok := n.index[name]; ok {
+		return c
+	}
+	c := &PathNode{
+		ID:    fmt.Sprintf("%d:%s", n.Depth+1, name),
+		Name:  name,
+		Depth: n.Depth + 1,
+	}
+	n.index[name] = c
+	n.Children = append(n.Children, c)
+	return c
+}
+
+// prune 子节点按次数倒序，只保留 topN 个，其余合并为 (other)
+func (n *PathNode) prune(topN int) {
+	sort.Slice(n.Children, func(i, j int) bool {
+		if n.Children[i].Count != n.Children[j].Count {
+			return n.Children[i].Count > n.Children[j].Count
+		}
+		return n.Children[i].Name < n.Children[j].Name
+	})
+
+	if topN > 0 && len(n.Children) > topN {
+		other := &PathNode{
+			ID:    fmt.Sprintf("%d:%s", n.Depth+1, PathNodeOther),
+			Name:  PathNodeOther,
+			Depth: n.Depth + 1,
+		}
+		for _, c := range n.Children[topN:] {
+			other.Count += c.Count
+		}
+		n.Children = append(n.Children[:topN:topN], other)
+	}
+
+	for _, c := range n.Children {
+		c.prune(topN)
+	}
+}
+
+// GetEventPaths 路径分析：按用户会话统计起始事件之后（或之前）最常见的事件序列
+// 先随机抽取最多 MaxUsers 个触发过起始事件的用户，再按用户分批加载事件（app_id + user_id + created_at 索引），
+// 扫描事件数达到 MaxEvents 时停止，结果基于已加载的用户
+func GetEventPaths(db *gorm.DB, q PathQuery) (*PathResult, error) {
+	var users []string
+	err := pathScope(db, q).
+		Where("event_name = ?", q.StartEvent).
+		Group("user_id").
+		Order("RANDOM()").
+		Limit(q.MaxUsers+1).
+		Pluck("user_id", &users).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PathResult{
+		StartEvent: q.StartEvent,
+		Direction:  q.Direction,
+		Tree:       &PathNode{ID: "0:" + q.StartEvent, Name: q.StartEvent},
+	}
+	if len(users) > q.MaxUsers {
+		users = users[:q.MaxUsers]
+		result.Truncated = true
+	}
+
+	var events []pathEvent
+	for i := 0; i < len(users); i += pathUserBatch {
+		remaining := q.MaxEvents - len(events)
+		if remaining <= 0 {
+			result.Truncated = true
+			break
+		}
+
+		query := pathScope(db, q).
+			Select("user_id, event_name, created_at").
+			Where("user_id IN ?", users[i:min(i+pathUserBatch, len(users))])
+		if len(q.ExcludeEvents) > 0 {
+			query = query.Where("event_name NOT IN ?", q.ExcludeEvents)
+		}
+		var batch []pathEvent
+		if err := query.Order("user_id ASC, created_at ASC").Limit(remaining + 1).Scan(&batch).Error; err != nil {
+			return nil, err
+		}
+
+		if len(batch) > remaining {
+			// 丢弃最后一个用户（可能不完整），保证每个会话都是完整的；
+			// 只有这一个用户时保留其前 MaxEvents 条事件，避免单个高频用户导致结果为空
+			batch = batch[:remaining]
+			complete := batch
+			last := complete[len(complete)-1].UserID
+			for len(complete) > 0 && complete[len(complete)-1].UserID == last {
+				complete = complete[:len(complete)-1]
+			}
+			if len(events)+len(complete) > 0 {
+				batch = complete
+			}
+			events = append(events, batch...)
+			result.Truncated = true
+			break
+		}
+		events = append(events, batch...)
+	}
+
+	// 按用户和不活跃间隔切分会话
+	start := 0
+	for i := 1; i <= len(events); i++ {
+		if i < len(events) &&
+			events[i].UserID == events[i-1].UserID &&
+			events[i].CreatedAt.Sub(events[i-1].CreatedAt) <= q.SessionGap {
+			continue
+		}
+		if result.addSession(events[start:i], q) {
+			result.Sessions++
+		}
+		start = i
+	}
+
+	result.Tree.Count = result.Sessions
+	result.Tree.prune(q.TopN)
+	result.Nodes, result.Links = flattenPathTree(result.Tree)
+	return result, nil
+}
+
+// pathScope 路径分析扫描的时间范围和应用
+func pathScope(db *gorm.DB, q PathQuery) *gorm.DB {
+	query := db.Model(&Event{}).Where("created_at >= ? AND created_at < ?", q.From, q.To)
+	if q.AppID != "" {
+		query = query.Where("app_id = ?", q.AppID)
+	}
+	return query
+}
+
+// addSession 将一个会话中起始事件前后的序列计入路径树，会话不包含起始事件时返回 false
+// 每个会话只取第一次（after）或最后一次（before）出现的起始事件，避免重复计数
+func (r *PathResult) addSession(session []pathEvent, q PathQuery) bool {
+	pos := -1
+	for i, e := range session {
+		if e.EventName == q.StartEvent {
+			pos = i
+			if q.Direction == PathDirectionAfter {
+				break
+			}
+		}
+	}
+	if pos < 0 {
+		return false
+	}
+
+	node := r.Tree
+	for step := 1; step <= q.MaxDepth; step++ {
+		i := pos + step
+		if q.Direction == PathDirectionBefore {
+			i = pos - step
+		}
+
+		name := PathNodeEnd
+		if i >= 0 && i < len(session) {
+			name = session[i].EventName
+		}
+
+		node = node.child(name)
+		node.Count++
+		if name == PathNodeEnd {
+			break
+		}
+	}
+	return true
+}
+
+// flattenPathTree 将路径树展开为 Sankey 的节点和连线（同一深度同名节点合并）
+func flattenPathTree(root *PathNode) ([]SankeyNode, []PathLink) {
+	nodes := []SankeyNode{{ID: root.ID, Name: root.Name, Depth: root.Depth}}
+	seen := map[string]bool{root.ID: true}
+	linkIndex := make(map[[2]string]int)
+	var links []PathLink
+
+	var walk func(n *PathNode)
+	walk = func(n *PathNode) {
+		for _, c := range n.Children {
+			if !seen[c.ID] {
+				seen[c.ID] = true
+				nodes = append(nodes, SankeyNode{ID: c.ID, Name: c.Name, Depth: c.Depth})
+			}
+			key := [2]string{n.ID, c.ID}
+			if idx, ok := linkIndex[key]; ok {
+				links[idx].Value += c.Count
+			} else {
+				linkIndex[key] = len(links)
+				links = append(links, PathLink{Source: n.ID, Target: c.ID, Value: c.Count})
+			}
+			walk(c)
+		}
+	}
+	walk(root)
+
+	if links == nil {
+		links = []PathLink{}
+	}
+	return nodes, links
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PendingEvent 自动发现的未知事件（等待管理员加入白名单）
+type PendingEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	EventName string    `gorm:"uniqueIndex" json:"eventName"`
+	AppID     string    `json:"appId"` // 最近一次上报的应用
+	Count     int64     `json:"count"` // 上报次数（不含加入白名单之后的上报）
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// AddPendingEvent 记录未知事件，已存在时累加次数
+func AddPendingEvent(db *gorm.DB, e PendingEvent) error {
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "event_name"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"count":     gorm.Expr("pending_events.count + excluded.count"),
+			"app_id":    gorm.Expr("excluded.app_id"),
+			"last_seen": gorm.Expr("excluded.last_seen"),
+		}),
+	}).Create(&e).Error
+}
+
+// ListPendingEvents 获取全部待审核事件（最近上报的在前）
+func ListPendingEvents(db *gorm.DB) ([]PendingEvent, error) {
+	var events []PendingEvent
+	err := db.Order("last_seen DESC").Find(&events).Error
+	return events, err
+}
+
+// ListPendingEventNames 获取全部待审核事件名称
+func ListPendingEventNames(db *gorm.DB) ([]string, error) {
+	var names []string
+	err := db.Model(&PendingEvent{}).Pluck("event_name", &names).Error
+	return names, err
+}
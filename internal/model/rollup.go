@@ -0,0 +1,386 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hanxi/tracely/internal/health"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 汇总表以 UTC 时间分桶，与 DATE(created_at) 的口径一致
+const (
+	hourBucketLayout = "2006-01-02 15:00:00"
+	dayBucketLayout  = "2006-01-02"
+
+	// rollupStateEvents 事件汇总任务的水位线名称
+	rollupStateEvents = "events"
+	// rollupChunk 单个事务处理的最大时间跨度，避免首次回填时事务过大
+	rollupChunk = 24 * time.Hour
+	// rollupGrace 小时结束后再等待一段时间才汇总：事件在写入事务中才记录时间（见 ingest），
+	// 等待时间只需覆盖写入事务从记录时间到提交的耗时
+	rollupGrace = 2 * time.Minute
+)
+
+// EventHourlyRollup 小时级事件计数汇总
+type EventHourlyRollup struct {
+	Bucket    string `gorm:"primaryKey"` // UTC 小时，格式 2006-01-02 15:00:00
+	AppID     string `gorm:"primaryKey"`
+	EventName string `gorm:"primaryKey"`
+	Count     int64
+}
+
+// EventDailyRollup 天级事件计数汇总
+type EventDailyRollup struct {
+	Date      string `gorm:"primaryKey"` // UTC 日期，格式 2006-01-02
+	AppID     string `gorm:"primaryKey"`
+	EventName string `gorm:"primaryKey"`
+	Count     int64
+}
+
+// EventUserRollup 小时级去重用户（精确 UV 草图）
+type EventUserRollup struct {
+	Bucket    string `gorm:"primaryKey"`
+	AppID     string `gorm:"primaryKey"`
+	EventName string `gorm:"primaryKey"`
+	UserID    string `gorm:"primaryKey"`
+}
+
+// RollupState 汇总任务水位线：早于 Watermark 的原始数据均已汇总
+type RollupState struct {
+	Name      string `gorm:"primaryKey"`
+	Watermark time.Time
+	UpdatedAt time.Time
+}
+
+// hourKey 返回时间所在 UTC 小时的分桶键
+func hourKey(t time.Time) string {
+	return t.UTC().Format(hourBucketLayout)
+}
+
+// dayKey 返回时间所在 UTC 日期的分桶键
+func dayKey(t time.Time) string {
+	return t.UTC().Format(dayBucketLayout)
+}
+
+// RollupWatermark 获取事件汇总水位线，尚未汇总过时返回零值
+func RollupWatermark(db *gorm.DB) (time.Time, error) {
+	var state RollupState
+	err := db.Where("name = ?", rollupStateEvents).First(&state).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, nil
+	}
+	return state.Watermark, err
+}
+
+// RollupEvents 将水位线到 upTo（向下取整到整点）之间已关闭的小时汇总进汇总表
+// 每个时间块在一个事务内完成：写入汇总并推进水位线，保证重复执行不会重复计数
+func RollupEvents(db *gorm.DB, upTo time.Time) error {
+	upTo = upTo.Truncate(time.Hour)
+
+	watermark, err := RollupWatermark(db)
+	if err != nil {
+		return err
+	}
+
+	if watermark.IsZero() {
+		// 首次运行：从最早一条事件所在的小时开始回填
+		var first []time.Time
+		if err := db.Model(&Event{}).Order("created_at ASC").Limit(1).Pluck("created_at", &first).Error; err != nil {
+			return err
+		}
+		watermark = upTo
+		if len(first) > 0 && first[0].Before(upTo) {
+			watermark = first[0].Truncate(time.Hour)
+		}
+	} else if !watermark.Before(upTo) {
+		return nil
+	}
+
+	for from := watermark; ; {
+		to := from.Add(rollupChunk)
+		if to.After(upTo) {
+			to = upTo
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if from.Before(to) {
+				if err := rollupRange(tx, from, to); err != nil {
+					return err
+				}
+			}
+			return tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "name"}},
+				DoUpdates: clause.AssignmentColumns([]string{"watermark", "updated_at"}),
+			}).Create(&RollupState{Name: rollupStateEvents, Watermark: to, UpdatedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("rollup %s - %s: %w", from.Format(time.RFC3339), to.Format(time.RFC3339), err)
+		}
+
+		if !to.Before(upTo) {
+			return nil
+		}
+		from = to
+	}
+}
+
+// rollupRange 汇总 [from, to) 内的原始事件（from、to 均为整点）
+func rollupRange(tx *gorm.DB, from, to time.Time) error {
+	statements := []string{
+		`INSERT INTO event_hourly_rollups (bucket, app_id, event_name, count)
+		SELECT strftime('%Y-%m-%d %H:00:00', created_at), app_id, event_name, COUNT(*)
+		FROM events WHERE created_at >= ? AND created_at < ?
+		GROUP BY 1, 2, 3
+		ON CONFLICT (bucket, app_id, event_name) DO UPDATE SET count = count + excluded.count`,
+
+		`INSERT INTO event_daily_rollups (date, app_id, event_name, count)
+		SELECT DATE(created_at), app_id, event_name, COUNT(*)
+		FROM events WHERE created_at >= ? AND created_at < ?
+		GROUP BY 1, 2, 3
+		ON CONFLICT (date, app_id, event_name) DO UPDATE SET count = count + excluded.count`,
+
+		`INSERT INTO event_user_rollups (bucket, app_id, event_name, user_id)
+		SELECT DISTINCT strftime('%Y-%m-%d %H:00:00', created_at), app_id, event_name, user_id
+		FROM events WHERE created_at >= ? AND created_at < ?
+		ON CONFLICT DO NOTHING`,
+	}
+
+	for _, stmt := range statements {
+		if err := tx.Exec(stmt, from, to).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartRollupWorker 启动后台汇总任务，定期把已关闭的小时写入汇总表
+func StartRollupWorker(db *gorm.DB, intervalSec int) {
+	if intervalSec <= 0 {
+		intervalSec = 300
+	}
+
+	run := func() {
+		health.Beat("rollup")
+		if err := RollupEvents(db, time.Now().Add(-rollupGrace)); err != nil {
+			fmt.Printf("[Tracely] Rollup failed: %v\n", err)
+		}
+	}
+
+	health.Register("rollup", time.Duration(intervalSec)*time.Second)
+	go func() {
+		run()
+
+		ticker := time.NewTicker(time.Duration(intervalSec) * time.Second)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			run()
+		}
+	}()
+}
+
+// rollupSplit 将查询区间 [from, to) 拆分为：原始数据头部 [from, mid0)、
+// 汇总区间 [mid0, mid1)、原始数据尾部 [mid1, to)
+// 汇总区间按 step（小时或天，Truncate 以 UTC 对齐）对齐且不超过水位线；无可用汇总时 ok 为 false
+func rollupSplit(watermark, from, to time.Time, step time.Duration) (mid0, mid1 time.Time, ok bool) {
+	if watermark.IsZero() {
+		return time.Time{}, time.Time{}, false
+	}
+
+	mid0 = from.Truncate(step)
+	if mid0.Before(from) {
+		mid0 = mid0.Add(step)
+	}
+	mid1 = watermark
+	if to.Before(mid1) {
+		mid1 = to
+	}
+	mid1 = mid1.Truncate(step)
+
+	if !mid0.Before(mid1) {
+		return time.Time{}, time.Time{}, false
+	}
+	return mid0, mid1, true
+}
+
+// rawEvents 构建原始事件查询，ranges 为若干 [from, to) 时间区间（取并集）
+func rawEvents(db *gorm.DB, appID, eventName string, ranges ...[2]time.Time) *gorm.DB {
+	query := db.Model(&Event{})
+
+	cond := db.Session(&gorm.Session{NewDB: true})
+	for i, r := range ranges {
+		if i == 0 {
+			cond = cond.Where("created_at >= ? AND created_at < ?", r[0], r[1])
+		} else {
+			cond = cond.Or("created_at >= ? AND created_at < ?", r[0], r[1])
+		}
+	}
+	query = query.Where(cond)
+
+	if appID != "" {
+		query = query.Where("app_id = ?", appID)
+	}
+	if eventName != "" {
+		query = query.Where("event_name = ?", eventName)
+	}
+	return query
+}
+
+// filterRollup 为汇总表查询添加应用和事件过滤
+func filterRollup(query *gorm.DB, appID, eventName string) *gorm.DB {
+	if appID != "" {
+		query = query.Where("app_id = ?", appID)
+	}
+	if eventName != "" {
+		query = query.Where("event_name = ?", eventName)
+	}
+	return query
+}
+
+// CountEventsBetween 统计 [from, to) 内的事件数：已关闭的小时读汇总表，其余读原始表
+func CountEventsBetween(db *gorm.DB, appID, eventName string, from, to time.Time) (int64, error) {
+	watermark, err := RollupWatermark(db)
+	if err != nil {
+		return 0, err
+	}
+
+	mid0, mid1, ok := rollupSplit(watermark, from, to, time.Hour)
+	if !ok {
+		var count int64
+		err := rawEvents(db, appID, eventName, [2]time.Time{from, to}).Count(&count).Error
+		return count, err
+	}
+
+	var rolled int64
+	err = filterRollup(db.Model(&EventHourlyRollup{}), appID, eventName).
+		Select("COALESCE(SUM(count), 0)").
+		Where("bucket >= ? AND bucket < ?", hourKey(mid0), hourKey(mid1)).
+		Scan(&rolled).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var raw int64
+	err = rawEvents(db, appID, eventName, [2]time.Time{from, mid0}, [2]time.Time{mid1, to}).Count(&raw).Error
+	return rolled + raw, err
+}
+
+// CountUniqueUsersBetween 统计 [from, to) 内的唯一用户数：汇总用户与原始用户取并集去重
+func CountUniqueUsersBetween(db *gorm.DB, appID, eventName string, from, to time.Time) (int64, error) {
+	watermark, err := RollupWatermark(db)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	mid0, mid1, ok := rollupSplit(watermark, from, to, time.Hour)
+	if !ok {
+		err := rawEvents(db, appID, eventName, [2]time.Time{from, to}).
+			Select("COUNT(DISTINCT user_id)").
+			Scan(&count).Error
+		return count, err
+	}
+
+	// SQLite 的复合查询不支持带括号的子句，这里手工拼接 UNION
+	filter, args := "", []interface{}{}
+	if appID != "" {
+		filter += " AND app_id = ?"
+		args = append(args, appID)
+	}
+	if eventName != "" {
+		filter += " AND event_name = ?"
+		args = append(args, eventName)
+	}
+
+	sql := "SELECT COUNT(*) FROM (" +
+		"SELECT user_id FROM event_user_rollups WHERE bucket >= ? AND bucket < ?" + filter +
+		" UNION " +
+		"SELECT user_id FROM events WHERE ((created_at >= ? AND created_at < ?) OR (created_at >= ? AND created_at < ?))" + filter +
+		")"
+	values := []interface{}{hourKey(mid0), hourKey(mid1)}
+	values = append(values, args...)
+	values = append(values, from, mid0, mid1, to)
+	values = append(values, args...)
+
+	err = db.Raw(sql, values...).Scan(&count).Error
+	return count, err
+}
+
+// countByEventNameBetween 按事件名称统计 [from, to) 内的事件数
+func countByEventNameBetween(db *gorm.DB, appID, eventName string, from, to time.Time) (map[string]int64, error) {
+	watermark, err := RollupWatermark(db)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64)
+	collect := func(query *gorm.DB) error {
+		var rows []EventStats
+		if err := query.Group("event_name").Scan(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			counts[row.EventName] += row.Count
+		}
+		return nil
+	}
+
+	mid0, mid1, ok := rollupSplit(watermark, from, to, time.Hour)
+	if !ok {
+		err := collect(rawEvents(db, appID, eventName, [2]time.Time{from, to}).
+			Select("event_name, COUNT(*) as count"))
+		return counts, err
+	}
+
+	err = collect(filterRollup(db.Model(&EventHourlyRollup{}), appID, eventName).
+		Select("event_name, SUM(count) as count").
+		Where("bucket >= ? AND bucket < ?", hourKey(mid0), hourKey(mid1)))
+	if err != nil {
+		return nil, err
+	}
+
+	err = collect(rawEvents(db, appID, eventName, [2]time.Time{from, mid0}, [2]time.Time{mid1, to}).
+		Select("event_name, COUNT(*) as count"))
+	return counts, err
+}
+
+// dailyCountsBetween 按 UTC 日期统计 [from, to) 内的事件数：整天读天级汇总，其余读原始表
+func dailyCountsBetween(db *gorm.DB, appID, eventName string, from, to time.Time) (map[string]int64, error) {
+	watermark, err := RollupWatermark(db)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64)
+	collect := func(query *gorm.DB, dateExpr string) error {
+		var rows []DailyEvent
+		if err := query.Group(dateExpr).Scan(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			counts[row.Date] += row.Count
+		}
+		return nil
+	}
+
+	mid0, mid1, ok := rollupSplit(watermark, from, to, 24*time.Hour)
+	if !ok {
+		err := collect(rawEvents(db, appID, eventName, [2]time.Time{from, to}).
+			Select("DATE(created_at) as date, COUNT(*) as count"), "DATE(created_at)")
+		return counts, err
+	}
+
+	err = collect(filterRollup(db.Model(&EventDailyRollup{}), appID, eventName).
+		Select("date, SUM(count) as count").
+		Where("date >= ? AND date < ?", dayKey(mid0), dayKey(mid1)), "date")
+	if err != nil {
+		return nil, err
+	}
+
+	err = collect(rawEvents(db, appID, eventName, [2]time.Time{from, mid0}, [2]time.Time{mid1, to}).
+		Select("DATE(created_at) as date, COUNT(*) as count"), "DATE(created_at)")
+	return counts, err
+}
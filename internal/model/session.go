@@ -0,0 +1,50 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Session 登录会话（Refresh Token 服务端记录，每次刷新轮换）
+type Session struct {
+	ID              uint       `gorm:"primaryKey" json:"-"`
+	SessionID       string     `gorm:"uniqueIndex" json:"sessionId"`
+	Username        string     `gorm:"index" json:"username"`
+	RefreshHash     string     `gorm:"uniqueIndex" json:"-"`
+	PrevRefreshHash string     `gorm:"index" json:"-"` // 上一个 Refresh Token，再次使用视为泄露
+	AccessJTI       string     `json:"-"`              // 最近签发的 Access Token
+	AccessExpiresAt time.Time  `json:"-"`              // 最近签发的 Access Token 过期时间
+	UserAgent       string     `json:"userAgent"`
+	IP              string     `json:"ip"`
+	ExpiresAt       time.Time  `json:"expiresAt"`
+	RevokedAt       *time.Time `json:"revokedAt"`
+	LastUsedAt      time.Time  `json:"lastUsedAt"`
+	CreatedAt       time.Time  `json:"createdAt"`
+}
+
+// IsActive 检查会话在指定时间是否有效（未吊销且未过期）
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// RevokedToken 已吊销的 Access Token（jti），过期后清理
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// ListUserSessions 获取用户的全部会话
+func ListUserSessions(db *gorm.DB, username string) ([]Session, error) {
+	var sessions []Session
+	err := db.Where("username = ?", username).Order("id DESC").Find(&sessions).Error
+	return sessions, err
+}
+
+// DeleteExpiredSessions 清理过期的会话和吊销记录
+func DeleteExpiredSessions(db *gorm.DB, now time.Time) error {
+	if err := db.Where("expires_at < ?", now).Delete(&Session{}).Error; err != nil {
+		return err
+	}
+	return db.Where("expires_at < ?", now).Delete(&RevokedToken{}).Error
+}
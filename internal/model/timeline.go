@@ -0,0 +1,262 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 时间线条目类型
+const (
+	TimelineKindEvent = "event"
+	TimelineKindError = "error"
+)
+
+// TimelineItem 用户时间线条目（事件与错误交错排列）
+type TimelineItem struct {
+	Kind        string          `json:"kind"` // event 或 error
+	Time        time.Time       `json:"time"`
+	AppID       string          `json:"appId"`
+	EventName   string          `json:"eventName,omitempty"`
+	Metadata    json.RawMessage `json:"metadata,omitempty"`
+	Fingerprint string          `json:"fingerprint,omitempty"`
+	ErrorType   string          `json:"errorType,omitempty"`
+	Message     string          `json:"message,omitempty"`
+	URL         string          `json:"url,omitempty"`
+
+	id uint // 事件或错误发生记录的 ID，用于生成分页游标
+}
+
+// TimelineCursor 时间线分页游标：按 时间、类型（事件在前）、ID 倒序排列，时间相同的条目翻页时不会被跳过
+type TimelineCursor struct {
+	Time time.Time
+	Kind string // 为空表示只按时间（返回早于 Time 的条目）
+	ID   uint
+}
+
+// String 游标格式为 时间（RFC3339Nano）,类型,ID
+func (c TimelineCursor) String() string {
+	return c.Time.Format(time.RFC3339Nano) + "," + c.Kind + "," + strconv.FormatUint(uint64(c.ID), 10)
+}
+
+// ParseTimelineCursor 解析分页游标，也接受只有时间的旧格式
+func ParseTimelineCursor(s string) (TimelineCursor, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 1 && len(parts) != 3 {
+		return TimelineCursor{}, errors.New("invalid timeline cursor")
+	}
+	t, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return TimelineCursor{}, err
+	}
+	cursor := TimelineCursor{Time: t}
+	if len(parts) == 3 {
+		if parts[1] != TimelineKindEvent && parts[1] != TimelineKindError {
+			return TimelineCursor{}, errors.New("invalid timeline cursor kind")
+		}
+		id, err := strconv.ParseUint(parts[2], 10, 64)
+		if err != nil {
+			return TimelineCursor{}, err
+		}
+		cursor.Kind, cursor.ID = parts[1], uint(id)
+	}
+	return cursor, nil
+}
+
+// kindRank 时间相同时的排序：事件在前
+func kindRank(kind string) int {
+	if kind == TimelineKindEvent {
+		return 1
+	}
+	return 0
+}
+
+// after 条目在倒序排列中是否排在 other 之前
+func (item TimelineItem) after(other TimelineItem) bool {
+	if !item.Time.Equal(other.Time) {
+		return item.Time.After(other.Time)
+	}
+	if kindRank(item.Kind) != kindRank(other.Kind) {
+		return kindRank(item.Kind) > kindRank(other.Kind)
+	}
+	return item.id > other.id
+}
+
+// UserErrorCount 用户遇到的错误统计
+type UserErrorCount struct {
+	Fingerprint string `json:"fingerprint"`
+	Type        string `json:"type"`
+	Message     string `json:"message"`
+	Count       int64  `json:"count"`
+}
+
+// UserTimeline 用户时间线
+type UserTimeline struct {
+	UserID      string           `json:"userId"`
+	FirstSeen   *time.Time       `json:"firstSeen"`
+	LastSeen    *time.Time       `json:"lastSeen"`
+	EventCounts []EventStats     `json:"eventCounts"` // 按事件名称统计
+	ErrorCounts []UserErrorCount `json:"errorCounts"` // 按错误指纹统计
+	Items       []TimelineItem   `json:"items"`       // 按时间倒序
+	NextBefore  string           `json:"nextBefore"`  // 下一页游标（作为 before 参数），为空表示没有更多
+}
+
+// occurrenceRow 错误发生记录与错误详情的联表结果
+type occurrenceRow struct {
+	ID          uint
+	Fingerprint string
+	AppID       string
+	Type        string
+	Message     string
+	URL         string
+	CreatedAt   time.Time
+}
+
+// GetUserTimeline 获取用户时间线：游标 before 之后最近的 limit 条事件与错误，以及首末次出现时间和计数
+func GetUserTimeline(db *gorm.DB, appID, userID string, before TimelineCursor, limit int) (*UserTimeline, error) {
+	eventQuery := func() *gorm.DB {
+		query := db.Model(&Event{}).Where("user_id = ?", userID)
+		if appID != "" {
+			query = query.Where("app_id = ?", appID)
+		}
+		return query
+	}
+	occurrenceQuery := func() *gorm.DB {
+		query := db.Model(&ErrorOccurrence{}).Where("error_occurrences.user_id = ?", userID)
+		if appID != "" {
+			query = query.Where("error_occurrences.app_id = ?", appID)
+		}
+		return query
+	}
+
+	timeline := &UserTimeline{UserID: userID}
+
+	// 首末次出现时间（事件与错误取并集）
+	for _, query := range []*gorm.DB{
+		eventQuery().Order("created_at ASC"),
+		eventQuery().Order("created_at DESC"),
+		occurrenceQuery().Order("created_at ASC"),
+		occurrenceQuery().Order("created_at DESC"),
+	} {
+		var times []time.Time
+		if err := query.Limit(1).Pluck("created_at", &times).Error; err != nil {
+			return nil, err
+		}
+		for _, t := range times {
+			timeline.seen(t)
+		}
+	}
+
+	// 按事件名称统计
+	if err := eventQuery().Select("event_name, COUNT(*) as count").
+		Group("event_name").Order("count DESC").Scan(&timeline.EventCounts).Error; err != nil {
+		return nil, err
+	}
+
+	// 按错误指纹统计
+	if err := occurrenceQuery().
+		Select("error_occurrences.fingerprint, error_logs.type, error_logs.message, COUNT(*) as count").
+		Joins("LEFT JOIN error_logs ON error_logs.fingerprint = error_occurrences.fingerprint").
+		Group("error_occurrences.fingerprint, error_logs.type, error_logs.message").
+		Order("count DESC").
+		Scan(&timeline.ErrorCounts).Error; err != nil {
+		return nil, err
+	}
+
+	// 两类记录各取 limit 条，合并后再截取，保证交错顺序正确
+	// 与游标时间相同的条目按类型、ID 决定是否已返回过
+	t := before.Time
+	events := eventQuery()
+	switch before.Kind {
+	case TimelineKindEvent:
+		events = events.Where("created_at < ? OR (created_at = ? AND id < ?)", t, t, before.ID)
+	default:
+		events = events.Where("created_at < ?", t)
+	}
+	occurrences := occurrenceQuery()
+	switch before.Kind {
+	case TimelineKindEvent:
+		occurrences = occurrences.Where("error_occurrences.created_at <= ?", t)
+	case TimelineKindError:
+		occurrences = occurrences.Where("error_occurrences.created_at < ? OR (error_occurrences.created_at = ? AND error_occurrences.id < ?)", t, t, before.ID)
+	default:
+		occurrences = occurrences.Where("error_occurrences.created_at < ?", t)
+	}
+
+	var eventRows []Event
+	if err := events.Order("created_at DESC, id DESC").Limit(limit).Find(&eventRows).Error; err != nil {
+		return nil, err
+	}
+
+	var occurrenceRows []occurrenceRow
+	if err := occurrences.
+		Select("error_occurrences.id, error_occurrences.fingerprint, error_occurrences.app_id, error_logs.type, error_logs.message, error_occurrences.url, error_occurrences.created_at").
+		Joins("LEFT JOIN error_logs ON error_logs.fingerprint = error_occurrences.fingerprint").
+		Order("error_occurrences.created_at DESC, error_occurrences.id DESC").Limit(limit).
+		Scan(&occurrenceRows).Error; err != nil {
+		return nil, err
+	}
+
+	items := make([]TimelineItem, 0, len(eventRows)+len(occurrenceRows))
+	for _, e := range eventRows {
+		items = append(items, TimelineItem{
+			Kind:      TimelineKindEvent,
+			Time:      e.CreatedAt,
+			AppID:     e.AppID,
+			EventName: e.EventName,
+			Metadata:  e.Metadata,
+			id:        e.ID,
+		})
+	}
+	for _, o := range occurrenceRows {
+		items = append(items, TimelineItem{
+			Kind:        TimelineKindError,
+			Time:        o.CreatedAt,
+			AppID:       o.AppID,
+			Fingerprint: o.Fingerprint,
+			ErrorType:   o.Type,
+			Message:     o.Message,
+			URL:         o.URL,
+			id:          o.ID,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].after(items[j]) })
+
+	// 合并后被截断，或任一类记录取满 limit 条（可能还有更早的），都可能有下一页
+	more := len(items) > limit || len(eventRows) == limit || len(occurrenceRows) == limit
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	if n := len(items); more && n > 0 {
+		last := items[n-1]
+		timeline.NextBefore = TimelineCursor{Time: last.Time, Kind: last.Kind, ID: last.id}.String()
+	}
+	timeline.Items = items
+
+	// 确保返回空数组而不是 null
+	if timeline.EventCounts == nil {
+		timeline.EventCounts = []EventStats{}
+	}
+	if timeline.ErrorCounts == nil {
+		timeline.ErrorCounts = []UserErrorCount{}
+	}
+
+	return timeline, nil
+}
+
+// seen 用一次出现时间更新首末次出现时间
+func (t *UserTimeline) seen(at time.Time) {
+	if t.FirstSeen == nil || at.Before(*t.FirstSeen) {
+		first := at
+		t.FirstSeen = &first
+	}
+	if t.LastSeen == nil || at.After(*t.LastSeen) {
+		last := at
+		t.LastSeen = &last
+	}
+}
@@ -0,0 +1,78 @@
+package model
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hanxi/tracely/internal/config"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// User Dashboard 用户
+type User struct {
+	ID            uint      `gorm:"primaryKey" json:"-"`
+	Username      string    `gorm:"uniqueIndex" json:"username"`
+	PasswordHash  string    `json:"-"`
+	Role          string    `json:"role"`                                                                 // admin / member / viewer
+	Apps          []string  `gorm:"serializer:json" json:"apps"`                                          // 允许访问的 AppID，为空表示全部应用
+	Source        string    `json:"source"`                                                               // 为空表示本地用户，oidc 表示由单点登录自动创建
+	OIDCIssuer    string    `gorm:"column:oidc_issuer;index:idx_user_oidc" json:"-"`                      // 关联的单点登录账号：IdP 的 issuer
+	OIDCSubject   string    `gorm:"column:oidc_subject;index:idx_user_oidc" json:"oidcSubject,omitempty"` // 关联的单点登录账号：IdP 的 sub（不可变的用户标识）
+	TOTPSecret    string    `json:"-"`                                                                    // 两步验证（TOTP）密钥，启用前为待确认的密钥
+	TOTPEnabled   bool      `json:"totpEnabled"`
+	TOTPRequired  bool      `json:"totpRequired"`             // 管理员要求该用户必须启用
+	TOTPLastStep  int64     `json:"-"`                        // 最近一次使用的时间步，防止验证码重放
+	RecoveryCodes []string  `gorm:"serializer:json" json:"-"` // 恢复码哈希，使用后移除
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// VerifyPassword 验证密码（未设置密码的用户同样执行一次 bcrypt，响应时间与密码错误一致）
+func (u *User) VerifyPassword(password string) bool {
+	if u.PasswordHash == "" {
+		VerifyDummyPassword(password)
+		return false
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password))
+	return err == nil
+}
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// VerifyDummyPassword 用户不存在时与固定哈希比对一次，使响应时间与密码错误一致，防止通过耗时枚举用户名
+func VerifyDummyPassword(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("tracely-dummy-password"), bcrypt.DefaultCost)
+	})
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
+
+// 用户来源
+const UserSourceOIDC = "oidc"
+
+// GetRole 获取用户角色（未设置时视为 admin）
+func (u *User) GetRole() string {
+	if u.Role == "" {
+		return config.RoleAdmin
+	}
+	return u.Role
+}
+
+// AllowedApps 获取允许访问的 AppID 列表，nil 表示不限制
+func (u *User) AllowedApps() []string {
+	if u.GetRole() == config.RoleAdmin || len(u.Apps) == 0 {
+		return nil
+	}
+	return u.Apps
+}
+
+// ListUsers 获取全部用户
+func ListUsers(db *gorm.DB) ([]User, error) {
+	var users []User
+	err := db.Order("id ASC").Find(&users).Error
+	return users, err
+}
@@ -0,0 +1,130 @@
+package oidc
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrNotAuthorized IdP 用户不属于任何已映射的分组且未配置默认角色
+var ErrNotAuthorized = errors.New("该账号未被授权访问 Tracely")
+
+// 登录流程中临时数据的有效期
+const (
+	AuthRequestTTL = 10 * time.Minute // 跳转 IdP 到回调之间（也是 state Cookie 的有效期）
+	loginCodeTTL   = time.Minute      // 回调到 Dashboard 兑换 Token 之间
+)
+
+// Identity 从 ID Token 映射出的 Tracely 用户
+type Identity struct {
+	Issuer   string // IdP 的 issuer
+	Subject  string // IdP 中不可变的用户标识（sub），与 Issuer 一起关联 Tracely 用户
+	Username string // 用户名取自可修改的 claim，只用于首次登录创建用户
+	Role     string
+	Apps     []string
+}
+
+// Identity 按配置的 claim 和分组映射解析用户名、角色和可访问应用
+func (p *Provider) Identity(claims jwt.MapClaims) (*Identity, error) {
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, errors.New("id_token has no sub claim")
+	}
+	username, _ := claims[p.cfg.UsernameClaim].(string)
+	if username == "" {
+		username, _ = claims["email"].(string)
+	}
+	if username == "" {
+		username = subject
+	}
+
+	groups := make(map[string]bool)
+	switch v := claims[p.cfg.GroupsClaim].(type) {
+	case []interface{}:
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups[s] = true
+			}
+		}
+	case string:
+		groups[v] = true
+	}
+
+	for _, m := range p.cfg.RoleMappings {
+		if groups[m.Group] {
+			return &Identity{Issuer: p.cfg.Issuer, Subject: subject, Username: username, Role: m.Role, Apps: m.Apps}, nil
+		}
+	}
+	if p.cfg.DefaultRole != "" {
+		return &Identity{Issuer: p.cfg.Issuer, Subject: subject, Username: username, Role: p.cfg.DefaultRole}, nil
+	}
+	return nil, ErrNotAuthorized
+}
+
+// maxPending 每类临时数据最多保存的数量，登录入口无需认证，达到上限时淘汰最早写入的数据
+const maxPending = 10000
+
+// pending 带过期时间的临时数据
+type pending[T any] struct {
+	key       string
+	value     T
+	expiresAt time.Time
+}
+
+// pendingMap 一次性临时数据（取出即删除）
+// 同一类数据的有效期相同，order 按写入顺序排列也就是按过期时间排列，写入时只需从队首清理
+type pendingMap[T any] struct {
+	mu    sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+}
+
+// put 写入数据，清理队首已过期的数据，数量达到上限时淘汰最早写入的数据
+func (m *pendingMap[T]) put(key string, value T, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if m.items == nil {
+		m.items = make(map[string]*list.Element)
+		m.order = list.New()
+	}
+	for e := m.order.Front(); e != nil; e = m.order.Front() {
+		if len(m.items) < maxPending && !now.After(e.Value.(*pending[T]).expiresAt) {
+			break
+		}
+		m.remove(e)
+	}
+	if e, ok := m.items[key]; ok {
+		m.remove(e)
+	}
+	m.items[key] = m.order.PushBack(&pending[T]{key: key, value: value, expiresAt: now.Add(ttl)})
+}
+
+// take 取出并删除数据，不存在或已过期时返回 false
+func (m *pendingMap[T]) take(key string) (T, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.items[key]
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	m.remove(e)
+	item := e.Value.(*pending[T])
+	if time.Now().After(item.expiresAt) {
+		var zero T
+		return zero, false
+	}
+	return item.value, true
+}
+
+// remove 删除数据，调用方需持有 mu
+func (m *pendingMap[T]) remove(e *list.Element) {
+	m.order.Remove(e)
+	delete(m.items, e.Value.(*pending[T]).key)
+}
@@ -0,0 +1,290 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hanxi/tracely/internal/config"
+)
+
+// Discovery IdP 的 /.well-known/openid-configuration 中用到的字段
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider OIDC 客户端（授权码 + PKCE）
+type Provider struct {
+	cfg    config.OIDC
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *Discovery
+	keys      map[string]interface{} // kid -> 公钥
+	keysAt    time.Time
+
+	requests   pendingMap[*AuthRequest] // state -> 登录请求
+	loginCodes pendingMap[string]       // 一次性登录码 -> 用户名
+}
+
+// keysMinRefresh 遇到未知 kid 时重新拉取 JWKS 的最小间隔，防止被恶意 Token 刷爆 IdP
+const keysMinRefresh = time.Minute
+
+// NewProvider 创建 OIDC 客户端（首次使用时才请求 IdP 的 discovery 文档）
+func NewProvider(cfg config.OIDC) *Provider {
+	return &Provider{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// getJSON 请求 URL 并解析 JSON
+func (p *Provider) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Discover 获取 IdP 配置（成功后缓存）
+func (p *Provider) Discover(ctx context.Context) (*Discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var d Discovery
+	wellKnown := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, &d); err != nil {
+		return nil, fmt.Errorf("oidc discovery failed: %w", err)
+	}
+	if d.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc issuer mismatch: got %q, want %q", d.Issuer, p.cfg.Issuer)
+	}
+	p.discovery = &d
+	return &d, nil
+}
+
+// AuthRequest 一次登录请求的 state / nonce / PKCE verifier
+type AuthRequest struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// randomString 生成 URL 安全的随机串
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewAuthRequest 生成新的登录请求参数
+func NewAuthRequest() (*AuthRequest, error) {
+	var r AuthRequest
+	for _, v := range []*string{&r.State, &r.Nonce, &r.Verifier} {
+		s, err := randomString(32)
+		if err != nil {
+			return nil, err
+		}
+		*v = s
+	}
+	return &r, nil
+}
+
+// SaveAuthRequest 保存登录请求，等待 IdP 回调
+func (p *Provider) SaveAuthRequest(r *AuthRequest) {
+	p.requests.put(r.State, r, AuthRequestTTL)
+}
+
+// TakeAuthRequest 根据回调中的 state 取出登录请求（只能使用一次）
+func (p *Provider) TakeAuthRequest(state string) (*AuthRequest, bool) {
+	return p.requests.take(state)
+}
+
+// IssueLoginCode 为已通过 IdP 认证的用户生成一次性登录码，由 Dashboard 兑换 Token
+// 避免把 Token 直接放在回调跳转的 URL 中
+func (p *Provider) IssueLoginCode(username string) (string, error) {
+	code, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	p.loginCodes.put(code, username, loginCodeTTL)
+	return code, nil
+}
+
+// RedeemLoginCode 兑换一次性登录码，返回用户名
+func (p *Provider) RedeemLoginCode(code string) (string, bool) {
+	return p.loginCodes.take(code)
+}
+
+// AuthCodeURL 构造跳转到 IdP 的授权地址（PKCE S256）
+func (p *Provider) AuthCodeURL(ctx context.Context, r *AuthRequest) (string, error) {
+	d, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(r.Verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {r.State},
+		"nonce":                 {r.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange 使用授权码换取 ID Token 并校验，返回 ID Token 中的 claims
+func (p *Provider) Exchange(ctx context.Context, code string, r *AuthRequest) (jwt.MapClaims, error) {
+	d, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {r.Verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc token request failed: %s: %s", resp.Status, body)
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("invalid oidc token response: %w", err)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("oidc token response has no id_token")
+	}
+	return p.VerifyIDToken(ctx, token.IDToken, r.Nonce)
+}
+
+// VerifyIDToken 校验 ID Token 签名（JWKS）、issuer、audience、有效期和 nonce
+func (p *Provider) VerifyIDToken(ctx context.Context, raw string, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(p.cfg.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, errors.New("invalid id_token: nonce mismatch")
+	}
+	return claims, nil
+}
+
+// key 根据 kid 获取公钥，未知 kid 时重新拉取 JWKS（IdP 轮换密钥）
+func (p *Provider) key(ctx context.Context, kid string) (interface{}, error) {
+	p.mu.Lock()
+	key, ok := p.lookupKey(kid)
+	stale := time.Since(p.keysAt) >= keysMinRefresh
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	d, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var set jwkSet
+	if err := p.getJSON(ctx, d.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+	keys, err := set.publicKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys, p.keysAt = keys, time.Now()
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+// lookupKey 查找公钥，Token 未指定 kid 且 JWKS 只有一个密钥时直接使用（需持有 mu）
+func (p *Provider) lookupKey(kid string) (interface{}, bool) {
+	if key, ok := p.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
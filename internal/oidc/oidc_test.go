@@ -0,0 +1,292 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hanxi/tracely/internal/config"
+)
+
+// mockIdP 测试用 IdP：discovery、JWKS 和 token 端点，授权码兑换时校验 PKCE
+type mockIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims // 签发 ID Token 时附加的 claims
+
+	mu    sync.Mutex
+	codes map[string]authorization // 授权码 -> 授权请求
+}
+
+// authorization 授权端点收到的参数
+type authorization struct {
+	nonce     string
+	challenge string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	idp := &mockIdP{t: t, key: key, codes: make(map[string]authorization)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Discovery{
+			Issuer:                idp.server.URL,
+			AuthorizationEndpoint: idp.server.URL + "/authorize",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JWKSURI:               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwkSet{Keys: []jwk{{
+			Kid: "k1",
+			Kty: "RSA",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// authorize 模拟用户在 IdP 登录：记录授权地址中的 nonce 和 PKCE challenge，返回授权码
+func (idp *mockIdP) authorize(authURL string) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		idp.t.Fatalf("parse auth url: %v", err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		idp.t.Fatalf("code_challenge_method = %q, want S256", q.Get("code_challenge_method"))
+	}
+
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	code := fmt.Sprintf("code-%d", len(idp.codes))
+	idp.codes[code] = authorization{nonce: q.Get("nonce"), challenge: q.Get("code_challenge")}
+	return code
+}
+
+// token 授权码兑换 ID Token
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	auth, ok := idp.codes[r.FormValue("code")]
+	delete(idp.codes, r.FormValue("code"))
+	idp.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || r.FormValue("grant_type") != "authorization_code" ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != auth.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign(auth.nonce)})
+}
+
+// sign 签发 ID Token
+func (idp *mockIdP) sign(nonce string) string {
+	claims := jwt.MapClaims{
+		"iss":   idp.server.URL,
+		"aud":   "tracely",
+		"sub":   "user-1",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": nonce,
+	}
+	for k, v := range idp.claims {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "k1"
+	raw, err := token.SignedString(idp.key)
+	if err != nil {
+		idp.t.Fatalf("sign id_token: %v", err)
+	}
+	return raw
+}
+
+func newTestProvider(idp *mockIdP) *Provider {
+	return NewProvider(config.OIDC{
+		Enabled:       true,
+		Issuer:        idp.server.URL,
+		ClientID:      "tracely",
+		RedirectURL:   "https://tracely.example.com/auth/oidc/callback",
+		Scopes:        []string{"openid", "profile", "email"},
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+		RoleMappings: []config.OIDCRoleMapping{
+			{Group: "tracely-admins", Role: "admin"},
+			{Group: "tracely-dev", Role: "developer", Apps: []string{"app-a"}},
+		},
+	})
+}
+
+func TestProviderLoginFlow(t *testing.T) {
+	idp := newMockIdP(t)
+	idp.claims = jwt.MapClaims{
+		"preferred_username": "alice",
+		"groups":             []string{"staff", "tracely-dev"},
+	}
+	p := newTestProvider(idp)
+	ctx := context.Background()
+
+	req, err := NewAuthRequest()
+	if err != nil {
+		t.Fatalf("NewAuthRequest: %v", err)
+	}
+	authURL, err := p.AuthCodeURL(ctx, req)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	p.SaveAuthRequest(req)
+	code := idp.authorize(authURL)
+
+	saved, ok := p.TakeAuthRequest(req.State)
+	if !ok {
+		t.Fatal("auth request not found by state")
+	}
+	if _, ok := p.TakeAuthRequest(req.State); ok {
+		t.Error("auth request can be taken twice")
+	}
+
+	claims, err := p.Exchange(ctx, code, saved)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	identity, err := p.Identity(claims)
+	if err != nil {
+		t.Fatalf("Identity: %v", err)
+	}
+	if identity.Issuer != idp.server.URL || identity.Subject != "user-1" || identity.Username != "alice" {
+		t.Errorf("identity = %+v", identity)
+	}
+	if identity.Role != "developer" || !slices.Equal(identity.Apps, []string{"app-a"}) {
+		t.Errorf("role = %q apps = %v, want developer [app-a]", identity.Role, identity.Apps)
+	}
+
+	// 授权码只能兑换一次，PKCE verifier 不匹配时 IdP 拒绝
+	if _, err := p.Exchange(ctx, code, saved); err == nil {
+		t.Error("Exchange accepted a used code")
+	}
+	other, _ := NewAuthRequest()
+	if _, err := p.Exchange(ctx, idp.authorize(authURL), other); err == nil {
+		t.Error("Exchange accepted a wrong code_verifier")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	idp := newMockIdP(t)
+	p := newTestProvider(idp)
+	ctx := context.Background()
+
+	if _, err := p.VerifyIDToken(ctx, idp.sign("n1"), "n1"); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+	if _, err := p.VerifyIDToken(ctx, idp.sign("n1"), "n2"); err == nil {
+		t.Error("nonce mismatch accepted")
+	}
+
+	idp.claims = jwt.MapClaims{"aud": "someone-else"}
+	if _, err := p.VerifyIDToken(ctx, idp.sign("n1"), "n1"); err == nil {
+		t.Error("wrong audience accepted")
+	}
+	idp.claims = jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}
+	if _, err := p.VerifyIDToken(ctx, idp.sign("n1"), "n1"); err == nil {
+		t.Error("expired token accepted")
+	}
+
+	// 其他密钥签名的 Token
+	forged := newMockIdP(t)
+	forged.server.URL = idp.server.URL
+	if _, err := p.VerifyIDToken(ctx, forged.sign("n1"), "n1"); err == nil {
+		t.Error("token signed by an unknown key accepted")
+	}
+}
+
+func TestIdentityRoleMapping(t *testing.T) {
+	p := NewProvider(config.OIDC{
+		Issuer:        "https://idp.example.com",
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+		RoleMappings: []config.OIDCRoleMapping{
+			{Group: "tracely-admins", Role: "admin"},
+			{Group: "tracely-dev", Role: "developer", Apps: []string{"app-a"}},
+		},
+	})
+
+	tests := []struct {
+		name     string
+		claims   jwt.MapClaims
+		username string
+		role     string
+		err      error
+	}{
+		{"first matching group wins", jwt.MapClaims{"sub": "s", "preferred_username": "bob", "groups": []interface{}{"tracely-dev", "tracely-admins"}}, "bob", "admin", nil},
+		{"single group as string", jwt.MapClaims{"sub": "s", "preferred_username": "bob", "groups": "tracely-dev"}, "bob", "developer", nil},
+		{"username falls back to email", jwt.MapClaims{"sub": "s", "email": "bob@example.com", "groups": "tracely-admins"}, "bob@example.com", "admin", nil},
+		{"username falls back to sub", jwt.MapClaims{"sub": "s", "groups": "tracely-admins"}, "s", "admin", nil},
+		{"no matching group", jwt.MapClaims{"sub": "s", "groups": []interface{}{"staff"}}, "", "", ErrNotAuthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := p.Identity(tt.claims)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err == nil && (identity.Username != tt.username || identity.Role != tt.role) {
+				t.Errorf("identity = %+v, want username %q role %q", identity, tt.username, tt.role)
+			}
+		})
+	}
+
+	if _, err := p.Identity(jwt.MapClaims{"groups": "tracely-admins"}); err == nil {
+		t.Error("identity without sub accepted")
+	}
+	p.cfg.DefaultRole = "viewer"
+	if identity, err := p.Identity(jwt.MapClaims{"sub": "s"}); err != nil || identity.Role != "viewer" {
+		t.Errorf("default role: identity = %+v err = %v", identity, err)
+	}
+}
+
+func TestPendingMapBounded(t *testing.T) {
+	var m pendingMap[int]
+	for i := 0; i < maxPending+10; i++ {
+		m.put(fmt.Sprint(i), i, time.Minute)
+	}
+	if len(m.items) != maxPending || m.order.Len() != maxPending {
+		t.Fatalf("len = %d/%d, want %d", len(m.items), m.order.Len(), maxPending)
+	}
+	if _, ok := m.take("0"); ok {
+		t.Error("oldest item not evicted")
+	}
+	if v, ok := m.take(fmt.Sprint(maxPending + 9)); !ok || v != maxPending+9 {
+		t.Errorf("newest item = %d, %v", v, ok)
+	}
+
+	m.put("expired", 1, -time.Second)
+	if _, ok := m.take("expired"); ok {
+		t.Error("expired item returned")
+	}
+}
@@ -0,0 +1,228 @@
+// Package scrub 上报数据脱敏：写入数据库前替换错误消息、堆栈、URL 和事件 metadata 中的敏感信息
+package scrub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 内置检测规则
+const (
+	DetectorEmail       = "email"
+	DetectorCreditCard  = "credit_card"
+	DetectorBearerToken = "bearer_token"
+	DetectorIPAddress   = "ip_address"
+)
+
+// DefaultReplacement 默认替换文本
+const DefaultReplacement = "[Filtered]"
+
+// Rules 脱敏规则
+type Rules struct {
+	Detectors   []string // 启用的内置检测规则
+	Patterns    []string // 自定义正则，匹配的内容整体替换
+	DenyKeys    []string // metadata 和 URL 查询参数中需要整体替换值的键（不区分大小写）
+	Replacement string   // 替换文本，为空时使用 DefaultReplacement
+}
+
+// detector 一条检测规则，valid 不为空时只替换通过校验的匹配（减少误判）
+type detector struct {
+	re    *regexp.Regexp
+	valid func(string) bool
+}
+
+var builtinDetectors = map[string]detector{
+	DetectorEmail: {
+		re: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+	},
+	DetectorCreditCard: {
+		re:    regexp.MustCompile(`\b\d(?:[ \-]?\d){12,18}\b`),
+		valid: luhnValid,
+	},
+	DetectorBearerToken: {
+		re: regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*|\beyJ[A-Za-z0-9_\-]+\.eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+`),
+	},
+	DetectorIPAddress: {
+		re:    regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|(?:[0-9A-Fa-f]{0,4}:){2,7}[0-9A-Fa-f]{0,4}`),
+		valid: ipValid,
+	},
+}
+
+// IsDetector 是否为内置检测规则名称
+func IsDetector(name string) bool {
+	_, ok := builtinDetectors[name]
+	return ok
+}
+
+// Scrubber 编译后的脱敏规则，nil 表示不脱敏
+type Scrubber struct {
+	detectors   []detector
+	denyKeys    map[string]bool
+	replacement string
+}
+
+// Compile 编译脱敏规则，规则为空时返回 nil
+func Compile(rules Rules) (*Scrubber, error) {
+	if len(rules.Detectors) == 0 && len(rules.Patterns) == 0 && len(rules.DenyKeys) == 0 {
+		return nil, nil
+	}
+
+	s := &Scrubber{denyKeys: make(map[string]bool, len(rules.DenyKeys)), replacement: rules.Replacement}
+	if s.replacement == "" {
+		s.replacement = DefaultReplacement
+	}
+	for _, name := range rules.Detectors {
+		d, ok := builtinDetectors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown detector %q", name)
+		}
+		s.detectors = append(s.detectors, d)
+	}
+	for _, pattern := range rules.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		s.detectors = append(s.detectors, detector{re: re})
+	}
+	for _, key := range rules.DenyKeys {
+		s.denyKeys[strings.ToLower(key)] = true
+	}
+	return s, nil
+}
+
+// String 替换文本中的敏感信息
+func (s *Scrubber) String(v string) string {
+	if s == nil || v == "" {
+		return v
+	}
+	for _, d := range s.detectors {
+		v = d.re.ReplaceAllStringFunc(v, func(m string) string {
+			if d.valid != nil && !d.valid(m) {
+				return m
+			}
+			return s.replacement
+		})
+	}
+	return v
+}
+
+// URL 替换 URL 中的用户名密码和禁止的查询参数值，其余查询参数和路径按文本规则脱敏
+func (s *Scrubber) URL(v string) string {
+	if s == nil || v == "" {
+		return v
+	}
+	u, err := url.Parse(v)
+	if err != nil || (u.RawQuery == "" && u.User == nil) {
+		return s.String(v)
+	}
+
+	if u.User != nil {
+		u.User = url.User(s.replacement)
+	}
+
+	// 查询参数解码后再检测，避免编码后的邮箱等漏检
+	query := u.Query()
+	for key, values := range query {
+		for i, value := range values {
+			if s.denyKeys[strings.ToLower(key)] {
+				values[i] = s.replacement
+			} else {
+				values[i] = s.String(value)
+			}
+		}
+	}
+	u.RawQuery = query.Encode()
+	u.Host = s.String(u.Host)
+	u.Path, u.RawPath = s.String(u.Path), ""
+	u.Fragment, u.RawFragment = s.String(u.Fragment), ""
+	return u.String()
+}
+
+// Metadata 脱敏事件 metadata（递归处理嵌套对象和数组），返回新的 map
+func (s *Scrubber) Metadata(m map[string]interface{}) map[string]interface{} {
+	if s == nil || m == nil {
+		return m
+	}
+	out := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		if s.denyKeys[strings.ToLower(key)] {
+			out[key] = s.replacement
+			continue
+		}
+		out[key] = s.value(value)
+	}
+	return out
+}
+
+func (s *Scrubber) value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return s.String(v)
+	case map[string]interface{}:
+		return s.Metadata(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = s.value(item)
+		}
+		return out
+	case float64:
+		return s.number(strconv.FormatFloat(v, 'f', -1, 64), v)
+	case json.Number:
+		return s.number(v.String(), v)
+	default:
+		return v
+	}
+}
+
+// number 数字按文本规则检测（如以数字上报的银行卡号），命中时替换为脱敏后的字符串
+func (s *Scrubber) number(text string, v interface{}) interface{} {
+	if scrubbed := s.String(text); scrubbed != text {
+		return scrubbed
+	}
+	return v
+}
+
+// ipValid 校验 IP 地址；IPv6 至少包含 3 段，避免把 Foo::bar 之类的代码片段当作地址
+func ipValid(s string) bool {
+	if net.ParseIP(s) == nil {
+		return false
+	}
+	if !strings.Contains(s, ":") {
+		return true
+	}
+	groups := 0
+	for _, g := range strings.Split(s, ":") {
+		if g != "" {
+			groups++
+		}
+	}
+	return groups >= 3
+}
+
+// luhnValid 银行卡号 Luhn 校验（忽略空格和连字符）
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
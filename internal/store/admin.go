@@ -0,0 +1,431 @@
+package store
+
+import (
+	"errors"
+	"slices"
+	"sort"
+
+	"github.com/hanxi/tracely/internal/config"
+	"github.com/hanxi/tracely/internal/model"
+	"github.com/hanxi/tracely/internal/oidc"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// sortByID 按主键升序排序（即创建顺序）
+func sortByID[T any](items []T, id func(T) uint) {
+	sort.Slice(items, func(i, j int) bool { return id(items[i]) < id(items[j]) })
+}
+
+// mutate 执行一次写操作，成功后刷新缓存
+func (s *Store) mutate(fn func(tx *gorm.DB) error) error {
+	if err := s.db.Transaction(fn); err != nil {
+		return err
+	}
+	return s.Reload()
+}
+
+// notFound 将 gorm 的记录不存在错误转换为 ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// AppInput 创建/修改应用参数
+type AppInput struct {
+	AppID          string
+	AppName        string
+	AllowedOrigins []string        // 修改时为 nil 表示不修改
+	Quota          *model.AppQuota // 修改时为 nil 表示不修改
+	Scrub          *model.AppScrub // 修改时为 nil 表示不修改
+}
+
+// CreateApp 创建应用及其第一个密钥，AppID 为空时自动生成，Secret 总是自动生成
+func (s *Store) CreateApp(input AppInput) (*model.App, *model.AppSecret, error) {
+	if input.AppID == "" {
+		id, err := GenerateSecret(8)
+		if err != nil {
+			return nil, nil, err
+		}
+		input.AppID = id
+	}
+	value, err := GenerateSecret(32)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	app := model.App{AppID: input.AppID, AppName: input.AppName, AllowedOrigins: input.AllowedOrigins}
+	if input.Quota != nil {
+		app.Quota = *input.Quota
+	}
+	if input.Scrub != nil {
+		app.Scrub = *input.Scrub
+	}
+	secret := model.AppSecret{AppID: input.AppID, Name: "default", Secret: value}
+	err = s.mutate(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&model.App{}).Where("app_id = ?", app.AppID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrConflict
+		}
+		if err := tx.Create(&app).Error; err != nil {
+			return err
+		}
+		return tx.Create(&secret).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &app, &secret, nil
+}
+
+// UpdateApp 修改应用名称、允许的来源、配额和脱敏规则
+func (s *Store) UpdateApp(appID string, input AppInput) (*model.App, error) {
+	var app model.App
+	err := s.mutate(func(tx *gorm.DB) error {
+		if err := tx.Where("app_id = ?", appID).First(&app).Error; err != nil {
+			return notFound(err)
+		}
+		app.AppName = input.AppName
+		if input.AllowedOrigins != nil {
+			app.AllowedOrigins = input.AllowedOrigins
+		}
+		if input.Quota != nil {
+			app.Quota = *input.Quota
+		}
+		if input.Scrub != nil {
+			app.Scrub = *input.Scrub
+		}
+		return tx.Save(&app).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &app, nil
+}
+
+// DeleteApp 删除应用及其密钥（已上报的数据保留）
+func (s *Store) DeleteApp(appID string) error {
+	return s.mutate(func(tx *gorm.DB) error {
+		result := tx.Where("app_id = ?", appID).Delete(&model.App{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return tx.Where("app_id = ?", appID).Delete(&model.AppSecret{}).Error
+	})
+}
+
+// UserInput 创建/修改用户参数，修改时零值字段不更新
+type UserInput struct {
+	Username     string
+	Password     string
+	Role         string
+	Apps         []string
+	TOTPRequired *bool   // 是否强制启用两步验证
+	OIDCIssuer   string  // 关联单点登录账号时 IdP 的 issuer
+	OIDCSubject  *string // 关联的单点登录账号（IdP 的 sub），空字符串表示取消关联
+}
+
+// CreateUser 创建用户
+func (s *Store) CreateUser(input UserInput) (*model.User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	user := model.User{
+		Username:     input.Username,
+		PasswordHash: string(hash),
+		Role:         input.Role,
+		Apps:         input.Apps,
+		TOTPRequired: input.TOTPRequired != nil && *input.TOTPRequired,
+	}
+	err = s.mutate(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&model.User{}).Where("username = ?", user.Username).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrConflict
+		}
+		if input.OIDCSubject != nil {
+			if err := linkOIDC(tx, &user, input.OIDCIssuer, *input.OIDCSubject); err != nil {
+				return err
+			}
+		}
+		return tx.Create(&user).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UpdateUser 修改用户密码、角色或可访问应用（Apps 为 nil 时不修改），
+// 修改密码、角色或可访问应用会吊销该用户的全部会话（Access Token 中携带角色和应用，不吊销会继续沿用旧权限）
+func (s *Store) UpdateUser(username string, input UserInput) (*model.User, error) {
+	var hash []byte
+	if input.Password != "" {
+		var err error
+		if hash, err = bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost); err != nil {
+			return nil, err
+		}
+	}
+
+	var user model.User
+	var changed bool
+	err := s.mutate(func(tx *gorm.DB) error {
+		if err := tx.Where("username = ?", username).First(&user).Error; err != nil {
+			return notFound(err)
+		}
+		before := user
+
+		if hash != nil {
+			user.PasswordHash = string(hash)
+		}
+		if input.Role != "" {
+			if user.GetRole() == config.RoleAdmin && input.Role != config.RoleAdmin {
+				if err := ensureOtherAdmin(tx, user.ID); err != nil {
+					return err
+				}
+			}
+			user.Role = input.Role
+		}
+		if input.Apps != nil {
+			user.Apps = input.Apps
+		}
+		if input.TOTPRequired != nil {
+			user.TOTPRequired = *input.TOTPRequired
+		}
+		if input.OIDCSubject != nil {
+			if err := linkOIDC(tx, &user, input.OIDCIssuer, *input.OIDCSubject); err != nil {
+				return err
+			}
+		}
+		changed = hash != nil || privilegesChanged(&before, &user)
+		return tx.Save(&user).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		if _, err := s.RevokeUserSessions(username); err != nil {
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// privilegesChanged 用户的角色或可访问应用是否变化
+func privilegesChanged(before, after *model.User) bool {
+	return before.GetRole() != after.GetRole() || !slices.Equal(before.AllowedApps(), after.AllowedApps())
+}
+
+// linkOIDC 将用户关联到单点登录账号（subject 为空表示取消关联），同一账号只能关联一个用户
+func linkOIDC(tx *gorm.DB, user *model.User, issuer, subject string) error {
+	if subject == "" {
+		user.OIDCIssuer, user.OIDCSubject = "", ""
+		return nil
+	}
+	var count int64
+	err := tx.Model(&model.User{}).
+		Where("id <> ? AND oidc_issuer = ? AND oidc_subject = ?", user.ID, issuer, subject).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrConflict
+	}
+	user.OIDCIssuer, user.OIDCSubject = issuer, subject
+	return nil
+}
+
+// DeleteUser 删除用户并吊销其全部会话（不允许删除最后一个管理员）
+func (s *Store) DeleteUser(username string) error {
+	err := s.mutate(func(tx *gorm.DB) error {
+		var user model.User
+		if err := tx.Where("username = ?", username).First(&user).Error; err != nil {
+			return notFound(err)
+		}
+		if user.GetRole() == config.RoleAdmin {
+			if err := ensureOtherAdmin(tx, user.ID); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&user).Error
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.RevokeUserSessions(username)
+	return err
+}
+
+// ErrOIDCNotLinked 单点登录账号未关联，且同名用户已存在（本地用户或已关联其他 IdP 账号）
+var ErrOIDCNotLinked = errors.New("该用户名已存在，请联系管理员关联单点登录账号")
+
+// ProvisionOIDCUser 单点登录时同步用户，按 issuer + sub 查找已关联的用户（用户名取自可修改的 claim，不能作为身份依据）：
+//  1. 已关联的用户直接登录，由 OIDC 创建的用户按 IdP 分组映射更新角色和可访问应用，本地用户的角色以 Tracely 中的设置为准
+//  2. 未关联时，同名的本地用户或已关联其他 IdP 账号的用户返回 ErrOIDCNotLinked，本地用户须由管理员关联后才能单点登录
+//     （升级前由 OIDC 创建、尚未记录 sub 的用户在首次登录时关联）
+//  3. 用户不存在时，create 为 true 则自动创建并关联，否则返回 ErrNotFound
+//
+// 角色或可访问应用随 IdP 分组变化时吊销该用户已有的会话
+func (s *Store) ProvisionOIDCUser(identity *oidc.Identity, create bool) (*model.User, error) {
+	var user model.User
+	var changed bool
+	err := s.mutate(func(tx *gorm.DB) error {
+		err := tx.Where("oidc_issuer = ? AND oidc_subject = ?", identity.Issuer, identity.Subject).First(&user).Error
+		if err == nil {
+			if user.Source != model.UserSourceOIDC {
+				return nil
+			}
+			before := user
+			user.Role, user.Apps = identity.Role, identity.Apps
+			changed = privilegesChanged(&before, &user)
+			return tx.Save(&user).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		err = tx.Where("username = ?", identity.Username).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if !create {
+				return ErrNotFound
+			}
+			user = model.User{
+				Username:    identity.Username,
+				Role:        identity.Role,
+				Apps:        identity.Apps,
+				Source:      model.UserSourceOIDC,
+				OIDCIssuer:  identity.Issuer,
+				OIDCSubject: identity.Subject,
+			}
+			return tx.Create(&user).Error
+		}
+		if err != nil {
+			return err
+		}
+		if user.Source != model.UserSourceOIDC || user.OIDCSubject != "" {
+			return ErrOIDCNotLinked
+		}
+		before := user
+		user.OIDCIssuer, user.OIDCSubject = identity.Issuer, identity.Subject
+		user.Role, user.Apps = identity.Role, identity.Apps
+		changed = privilegesChanged(&before, &user)
+		return tx.Save(&user).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		if _, err := s.RevokeUserSessions(user.Username); err != nil {
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// ensureOtherAdmin 确认除指定用户外仍有管理员（未设置角色的用户视为管理员）
+func ensureOtherAdmin(tx *gorm.DB, excludeID uint) error {
+	var count int64
+	err := tx.Model(&model.User{}).
+		Where("id <> ? AND (role = ? OR role = '' OR role IS NULL)", excludeID, config.RoleAdmin).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// EventInput 创建/修改事件定义参数
+type EventInput struct {
+	EventName     string
+	Match         string // 匹配方式，仅创建时有效，为空表示 exact
+	Description   string
+	RetentionDays int
+	Schema        *model.MetadataSchema // 为 nil 表示不校验 metadata
+	UpdateSchema  bool                  // 修改时是否更新 Schema（为 false 表示不修改）
+}
+
+// CreateEvent 添加事件到白名单，并移除其匹配的待审核事件
+func (s *Store) CreateEvent(input EventInput) (*model.EventDefinition, error) {
+	event := model.EventDefinition{
+		EventName:     input.EventName,
+		Match:         input.Match,
+		Description:   input.Description,
+		RetentionDays: input.RetentionDays,
+		Schema:        input.Schema,
+	}
+	if event.Match == "" {
+		event.Match = model.EventMatchExact
+	}
+	err := s.mutate(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&model.EventDefinition{}).Where("event_name = ?", event.EventName).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrConflict
+		}
+		if err := tx.Create(&event).Error; err != nil {
+			return err
+		}
+		return removeMatchedPendingEvents(tx, event)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	re, _ := event.CompilePattern()
+	s.forgetPendingEvents(func(name string) bool {
+		return name == event.EventName || (re != nil && re.MatchString(name))
+	})
+	return &event, nil
+}
+
+// UpdateEvent 修改事件描述、保留天数和 metadata 约束
+func (s *Store) UpdateEvent(eventName string, input EventInput) (*model.EventDefinition, error) {
+	var event model.EventDefinition
+	err := s.mutate(func(tx *gorm.DB) error {
+		if err := tx.Where("event_name = ?", eventName).First(&event).Error; err != nil {
+			return notFound(err)
+		}
+		event.Description = input.Description
+		event.RetentionDays = input.RetentionDays
+		if input.UpdateSchema {
+			event.Schema = input.Schema
+		}
+		return tx.Save(&event).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// DeleteEvent 从白名单移除事件（已上报的数据保留）
+func (s *Store) DeleteEvent(eventName string) error {
+	return s.mutate(func(tx *gorm.DB) error {
+		result := tx.Where("event_name = ?", eventName).Delete(&model.EventDefinition{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
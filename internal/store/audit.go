@@ -0,0 +1,14 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/hanxi/tracely/internal/model"
+)
+
+// RecordAudit 写入审计日志（写入失败只打印日志，不影响业务操作）
+func (s *Store) RecordAudit(entry model.AuditLog) {
+	if err := s.db.Create(&entry).Error; err != nil {
+		fmt.Printf("[Tracely] Failed to record audit log %s: %v\n", entry.Action, err)
+	}
+}
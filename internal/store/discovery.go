@@ -0,0 +1,206 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hanxi/tracely/internal/config"
+	"github.com/hanxi/tracely/internal/health"
+	"github.com/hanxi/tracely/internal/model"
+	"gorm.io/gorm"
+)
+
+// maxDiscoveredNameLength 自动发现的事件名称最大长度，更长的名称直接拒绝
+const maxDiscoveredNameLength = 128
+
+// discoveryState 未知事件自动发现的配置和内存状态，待审核事件定期写入数据库
+type discoveryState struct {
+	mu      sync.Mutex
+	cfg     config.EventDiscovery
+	known   map[string]bool                // 待审核列表中的事件名称（含尚未写入的）
+	pending map[string]*model.PendingEvent // 事件名称 -> 待写入的上报次数
+}
+
+// SetEventDiscovery 设置未知事件自动发现参数
+func (s *Store) SetEventDiscovery(cfg config.EventDiscovery) {
+	s.discovery.mu.Lock()
+	s.discovery.cfg = cfg
+	s.discovery.mu.Unlock()
+}
+
+// loadPendingEvents 从数据库加载待审核事件名称（用于限制待审核列表长度）
+func (s *Store) loadPendingEvents() error {
+	names, err := model.ListPendingEventNames(s.db)
+	if err != nil {
+		return fmt.Errorf("failed to load pending events: %w", err)
+	}
+
+	d := &s.discovery
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, name := range names {
+		d.known[name] = true
+	}
+	return nil
+}
+
+// DiscoverEvent 记录一次不在白名单中的事件上报，返回 false 表示未开启自动发现或待审核列表已满
+func (s *Store) DiscoverEvent(eventName, appID string) bool {
+	if len(eventName) > maxDiscoveredNameLength {
+		return false
+	}
+	now := time.Now()
+
+	d := &s.discovery
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.cfg.Enabled {
+		return false
+	}
+	if !d.known[eventName] && len(d.known) >= d.cfg.MaxPending {
+		return false
+	}
+	d.known[eventName] = true
+
+	e, ok := d.pending[eventName]
+	if !ok {
+		e = &model.PendingEvent{EventName: eventName, FirstSeen: now}
+		d.pending[eventName] = e
+	}
+	e.AppID = appID
+	e.Count++
+	e.LastSeen = now
+	return true
+}
+
+// FlushPendingEvents 将内存中的待审核事件在一个事务中写入数据库，失败时放回内存等待下次写入
+func (s *Store) FlushPendingEvents() error {
+	d := &s.discovery
+	d.mu.Lock()
+	pending := d.pending
+	d.pending = make(map[string]*model.PendingEvent)
+	d.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for _, e := range pending {
+			if err := model.AddPendingEvent(tx, *e); err != nil {
+				return fmt.Errorf("failed to flush pending event %s: %w", e.EventName, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		d.mu.Lock()
+		for name, e := range pending {
+			if !d.known[name] {
+				continue // 期间已加入白名单或被忽略
+			}
+			if cur, ok := d.pending[name]; ok {
+				cur.Count += e.Count
+				cur.FirstSeen = e.FirstSeen
+			} else {
+				d.pending[name] = e
+			}
+		}
+		d.mu.Unlock()
+	}
+	return err
+}
+
+// StartPendingEventFlusher 启动定时写入待审核事件
+func (s *Store) StartPendingEventFlusher(interval time.Duration) {
+	health.Register("pending-event-flusher", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			health.Beat("pending-event-flusher")
+			if err := s.FlushPendingEvents(); err != nil {
+				fmt.Printf("[Tracely] %v\n", err)
+			}
+		}
+	}()
+}
+
+// ListPendingEvents 获取全部待审核事件（从数据库读取）
+func (s *Store) ListPendingEvents() ([]model.PendingEvent, error) {
+	if err := s.FlushPendingEvents(); err != nil {
+		return nil, err
+	}
+	return model.ListPendingEvents(s.db)
+}
+
+// ApprovePendingEvent 将待审核事件加入白名单（exact 匹配）并从待审核列表移除
+func (s *Store) ApprovePendingEvent(eventName string, input EventInput) (*model.EventDefinition, error) {
+	if err := s.FlushPendingEvents(); err != nil {
+		return nil, err
+	}
+	input.EventName = eventName
+	input.Match = model.EventMatchExact
+
+	var count int64
+	if err := s.db.Model(&model.PendingEvent{}).Where("event_name = ?", eventName).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrNotFound
+	}
+	return s.CreateEvent(input)
+}
+
+// DismissPendingEvent 从待审核列表移除事件（之后再次上报会重新出现在列表中）
+func (s *Store) DismissPendingEvent(eventName string) error {
+	if err := s.FlushPendingEvents(); err != nil {
+		return err
+	}
+	result := s.db.Where("event_name = ?", eventName).Delete(&model.PendingEvent{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	s.forgetPendingEvents(func(name string) bool { return name == eventName })
+	return nil
+}
+
+// removeMatchedPendingEvents 新的事件定义加入白名单后，移除其匹配的待审核事件
+func removeMatchedPendingEvents(tx *gorm.DB, event model.EventDefinition) error {
+	re, err := event.CompilePattern()
+	if err != nil {
+		return err
+	}
+	names, err := model.ListPendingEventNames(tx)
+	if err != nil {
+		return err
+	}
+	var matched []string
+	for _, name := range names {
+		if name == event.EventName || (re != nil && re.MatchString(name)) {
+			matched = append(matched, name)
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+	return tx.Where("event_name IN ?", matched).Delete(&model.PendingEvent{}).Error
+}
+
+// forgetPendingEvents 从内存中移除满足条件的待审核事件
+func (s *Store) forgetPendingEvents(match func(string) bool) {
+	d := &s.discovery
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for name := range d.known {
+		if match(name) {
+			delete(d.known, name)
+			delete(d.pending, name)
+		}
+	}
+}
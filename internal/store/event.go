@@ -0,0 +1,82 @@
+package store
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/hanxi/tracely/internal/model"
+)
+
+// eventPattern 编译好的 glob / regex 事件定义
+type eventPattern struct {
+	re    *regexp.Regexp
+	event model.EventDefinition
+}
+
+// buildEventMatcher 按匹配方式拆分事件定义：exact 事件按名称建索引，glob / regex 事件编译后按创建顺序排列
+func buildEventMatcher(events []model.EventDefinition) (map[string]model.EventDefinition, []eventPattern) {
+	exact := make(map[string]model.EventDefinition, len(events))
+	var patterns []eventPattern
+	for _, event := range events {
+		re, err := event.CompilePattern()
+		if err != nil {
+			fmt.Printf("[Tracely] Warning: invalid pattern for event %s, ignored: %v\n", event.EventName, err)
+			continue
+		}
+		if re == nil {
+			exact[event.EventName] = event
+			continue
+		}
+		patterns = append(patterns, eventPattern{re: re, event: event})
+	}
+	return exact, patterns
+}
+
+// IsEventAllowed 检查事件是否在白名单中
+func (s *Store) IsEventAllowed(eventName string) bool {
+	_, ok := s.GetEvent(eventName)
+	return ok
+}
+
+// GetEvent 获取上报的事件名称对应的事件定义：优先完全匹配，其次按创建顺序取第一个匹配的 glob / regex 事件
+func (s *Store) GetEvent(eventName string) (model.EventDefinition, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if event, ok := s.events[eventName]; ok {
+		return event, true
+	}
+	for _, p := range s.eventPatterns {
+		if p.re.MatchString(eventName) {
+			return p.event, true
+		}
+	}
+	return model.EventDefinition{}, false
+}
+
+// hasEventDefinition 是否存在名称（或匹配模式）为 eventName 的事件定义，调用方需持有读锁
+func (s *Store) hasEventDefinition(eventName string) bool {
+	if _, ok := s.events[eventName]; ok {
+		return true
+	}
+	for _, p := range s.eventPatterns {
+		if p.event.EventName == eventName {
+			return true
+		}
+	}
+	return false
+}
+
+// Events 获取全部事件定义（按创建顺序）
+func (s *Store) Events() []model.EventDefinition {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	events := make([]model.EventDefinition, 0, len(s.events)+len(s.eventPatterns))
+	for _, event := range s.events {
+		events = append(events, event)
+	}
+	for _, p := range s.eventPatterns {
+		events = append(events, p.event)
+	}
+	sortByID(events, func(e model.EventDefinition) uint { return e.ID })
+	return events
+}
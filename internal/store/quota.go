@@ -0,0 +1,353 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/hanxi/tracely/internal/config"
+	"github.com/hanxi/tracely/internal/health"
+	"github.com/hanxi/tracely/internal/model"
+	"gorm.io/gorm"
+)
+
+// 上报未被接收的原因
+const (
+	DropQuota = "quota" // 超出每日/每月配额
+	DropSpike = "spike" // 突增保护采样丢弃
+)
+
+// Admission 上报配额检查结果
+type Admission struct {
+	Allowed    bool
+	Reason     string        // 未接收时为 DropQuota 或 DropSpike
+	RetryAfter time.Duration // 超出配额时距离配额重置的时间
+}
+
+// usageKey 应用 + 上报类型
+type usageKey struct {
+	appID string
+	kind  string
+}
+
+// usageDayKey 应用 + 上报类型 + 日期（待写入数据库的用量）
+type usageDayKey struct {
+	usageKey
+	day string
+}
+
+// spikeWindow 突增检测：当前分钟的数量和每分钟数量的指数滑动平均（基线）
+type spikeWindow struct {
+	minute   int64 // Unix 分钟
+	count    float64
+	baseline float64
+}
+
+// quotaState 配额和突增保护的内存状态，用量定期写入数据库
+type quotaState struct {
+	mu      sync.Mutex
+	spike   config.SpikeProtection
+	day     string
+	month   string
+	daily   map[usageKey]int64 // 当天已接收
+	monthly map[usageKey]int64 // 当月已接收
+	pending map[usageDayKey]*model.AppUsage
+	windows map[usageKey]*spikeWindow
+}
+
+// SetSpikeProtection 设置突增保护参数
+func (s *Store) SetSpikeProtection(cfg config.SpikeProtection) {
+	s.quota.mu.Lock()
+	s.quota.spike = cfg
+	s.quota.mu.Unlock()
+}
+
+// loadUsage 从数据库加载当天和当月已接收的数量（重启后配额继续累计）
+func (s *Store) loadUsage(now time.Time) error {
+	day, month := model.UsageDay(now), monthStart(now)
+	rows, err := model.SumAppUsage(s.db, month)
+	if err != nil {
+		return fmt.Errorf("failed to load app usage: %w", err)
+	}
+	today, err := model.SumAppUsage(s.db, day)
+	if err != nil {
+		return fmt.Errorf("failed to load app usage: %w", err)
+	}
+
+	q := &s.quota
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.day, q.month = day, month
+	q.daily = make(map[usageKey]int64)
+	q.monthly = make(map[usageKey]int64)
+	for _, u := range rows {
+		q.monthly[usageKey{u.AppID, u.Kind}] = u.Accepted
+	}
+	for _, u := range today {
+		q.daily[usageKey{u.AppID, u.Kind}] = u.Accepted
+	}
+	return nil
+}
+
+// Admit 检查应用是否还能接收一条指定类型的上报，并记录用量
+// 先做突增检测（突增期间按比例采样），再检查每日/每月配额
+func (s *Store) Admit(appID, kind string) Admission {
+	app, _ := s.GetApp(appID)
+	now := time.Now().UTC()
+	key := usageKey{appID, kind}
+
+	q := &s.quota
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.roll(now)
+	usage := q.pendingUsage(key, q.day)
+
+	if q.spiking(key, now) && rand.Float64() >= q.spike.SampleRate {
+		usage.SpikeDropped++
+		return Admission{Reason: DropSpike}
+	}
+
+	daily, monthly := app.Quota.Limits(kind)
+	if daily > 0 && q.daily[key] >= daily {
+		usage.QuotaDropped++
+		return Admission{Reason: DropQuota, RetryAfter: now.Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(now)}
+	}
+	if monthly > 0 && q.monthly[key] >= monthly {
+		usage.QuotaDropped++
+		return Admission{Reason: DropQuota, RetryAfter: time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC).Sub(now)}
+	}
+
+	q.daily[key]++
+	q.monthly[key]++
+	usage.Accepted++
+	return Admission{Allowed: true}
+}
+
+// CancelAdmit 已通过配额检查的上报最终未被接收（如写入队列已满），撤销计数
+func (s *Store) CancelAdmit(appID, kind string) {
+	key := usageKey{appID, kind}
+
+	q := &s.quota
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.daily[key] > 0 {
+		q.daily[key]--
+	}
+	if q.monthly[key] > 0 {
+		q.monthly[key]--
+	}
+	q.pendingUsage(key, q.day).Accepted--
+}
+
+// roll 跨天/跨月时重置计数
+func (q *quotaState) roll(now time.Time) {
+	if day := model.UsageDay(now); day != q.day {
+		q.day = day
+		q.daily = make(map[usageKey]int64)
+	}
+	if month := monthStart(now); month != q.month {
+		q.month = month
+		q.monthly = make(map[usageKey]int64)
+	}
+}
+
+// pendingUsage 获取待写入的用量记录
+func (q *quotaState) pendingUsage(key usageKey, day string) *model.AppUsage {
+	dk := usageDayKey{key, day}
+	u, ok := q.pending[dk]
+	if !ok {
+		u = &model.AppUsage{AppID: key.appID, Kind: key.kind, Day: day}
+		q.pending[dk] = u
+	}
+	return u
+}
+
+// spiking 记录一次上报并判断当前分钟是否处于突增状态
+func (q *quotaState) spiking(key usageKey, now time.Time) bool {
+	w, ok := q.windows[key]
+	if !ok {
+		w = &spikeWindow{minute: now.Unix() / 60}
+		q.windows[key] = w
+	}
+	q.advance(w, now)
+	w.count++
+
+	return q.spike.Enabled && w.count > q.threshold(w)
+}
+
+// threshold 触发突增保护的每分钟数量
+func (q *quotaState) threshold(w *spikeWindow) float64 {
+	return math.Max(float64(q.spike.MinPerMinute), q.spike.Multiplier*w.baseline)
+}
+
+// advance 进入新的一分钟时把上一分钟的数量并入基线（中间没有上报的分钟按 0 计）
+// 超过阈值的分钟不并入基线，否则持续的洪泛会把基线抬高，几分钟后保护自动失效
+func (q *quotaState) advance(w *spikeWindow, now time.Time) {
+	minute := now.Unix() / 60
+	if minute <= w.minute {
+		return
+	}
+	alpha := 1 / float64(max(q.spike.BaselineMinutes, 1))
+	if !q.spike.Enabled || w.count <= q.threshold(w) {
+		w.baseline += alpha * (w.count - w.baseline)
+	}
+	if idle := minute - w.minute - 1; idle > 0 {
+		w.baseline *= math.Pow(1-alpha, float64(idle))
+	}
+	w.minute, w.count = minute, 0
+}
+
+// FlushAppUsage 将内存中的用量在一个事务中写入数据库，失败时放回内存等待下次写入
+func (s *Store) FlushAppUsage() error {
+	q := &s.quota
+	q.mu.Lock()
+	pending := q.pending
+	q.pending = make(map[usageDayKey]*model.AppUsage)
+	q.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for _, u := range pending {
+			if err := model.AddAppUsage(tx, *u); err != nil {
+				return fmt.Errorf("failed to flush usage of app %s: %w", u.AppID, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		q.mu.Lock()
+		for dk, u := range pending {
+			if cur, ok := q.pending[dk]; ok {
+				cur.Accepted += u.Accepted
+				cur.QuotaDropped += u.QuotaDropped
+				cur.SpikeDropped += u.SpikeDropped
+			} else {
+				q.pending[dk] = u
+			}
+		}
+		q.mu.Unlock()
+	}
+	return err
+}
+
+// StartAppUsageFlusher 启动定时写入应用用量
+func (s *Store) StartAppUsageFlusher(interval time.Duration) {
+	health.Register("app-usage-flusher", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			health.Beat("app-usage-flusher")
+			if err := s.FlushAppUsage(); err != nil {
+				fmt.Printf("[Tracely] %v\n", err)
+			}
+		}
+	}()
+}
+
+// SpikeStatus 应用某类上报的突增检测状态
+type SpikeStatus struct {
+	Active        bool    `json:"active"`        // 当前分钟是否处于突增保护
+	CurrentMinute float64 `json:"currentMinute"` // 当前分钟收到的数量（含丢弃）
+	Baseline      float64 `json:"baseline"`      // 每分钟数量的滑动平均
+	Threshold     float64 `json:"threshold"`     // 触发保护的每分钟数量
+}
+
+// UsageTotals 一段时间内的用量
+type UsageTotals struct {
+	Accepted     int64 `json:"accepted"`
+	QuotaDropped int64 `json:"quotaDropped"`
+	SpikeDropped int64 `json:"spikeDropped"`
+}
+
+func (t *UsageTotals) add(u model.AppUsage) {
+	t.Accepted += u.Accepted
+	t.QuotaDropped += u.QuotaDropped
+	t.SpikeDropped += u.SpikeDropped
+}
+
+// AppUsageReport 应用用量报告
+type AppUsageReport struct {
+	AppID string                 `json:"appId"`
+	Quota model.AppQuota         `json:"quota"`
+	Today map[string]UsageTotals `json:"today"` // 上报类型 -> 当天用量
+	Month map[string]UsageTotals `json:"month"` // 上报类型 -> 当月用量
+	Spike map[string]SpikeStatus `json:"spike"` // 上报类型 -> 突增检测状态
+	Daily []model.AppUsage       `json:"daily"` // 最近 days 天的每日用量
+}
+
+// AppUsage 获取应用的配额、当天/当月用量、突增状态和最近 days 天的每日用量
+func (s *Store) AppUsage(appID string, days int) (*AppUsageReport, error) {
+	app, ok := s.GetApp(appID)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := s.FlushAppUsage(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	today, month := model.UsageDay(now), monthStart(now)
+	from := model.UsageDay(now.AddDate(0, 0, 1-days))
+	rows, err := model.ListAppUsage(s.db, appID, min(from, month))
+	if err != nil {
+		return nil, err
+	}
+
+	report := &AppUsageReport{
+		AppID: appID,
+		Quota: app.Quota,
+		Today: make(map[string]UsageTotals),
+		Month: make(map[string]UsageTotals),
+		Spike: make(map[string]SpikeStatus),
+		Daily: make([]model.AppUsage, 0, len(rows)),
+	}
+	for _, kind := range []string{model.UsageKindError, model.UsageKindEvent} {
+		report.Today[kind] = UsageTotals{}
+		report.Month[kind] = UsageTotals{}
+	}
+	for _, u := range rows {
+		if u.Day == today {
+			t := report.Today[u.Kind]
+			t.add(u)
+			report.Today[u.Kind] = t
+		}
+		if u.Day >= month {
+			t := report.Month[u.Kind]
+			t.add(u)
+			report.Month[u.Kind] = t
+		}
+		if u.Day >= from {
+			report.Daily = append(report.Daily, u)
+		}
+	}
+
+	q := &s.quota
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, kind := range []string{model.UsageKindError, model.UsageKindEvent} {
+		status := SpikeStatus{Threshold: float64(q.spike.MinPerMinute)}
+		if w, ok := q.windows[usageKey{appID, kind}]; ok {
+			q.advance(w, now)
+			status.CurrentMinute = w.count
+			status.Baseline = w.baseline
+			status.Threshold = q.threshold(w)
+			status.Active = q.spike.Enabled && w.count > status.Threshold
+		}
+		report.Spike[kind] = status
+	}
+	return report, nil
+}
+
+// monthStart 当月第一天的用量统计日期
+func monthStart(t time.Time) string {
+	t = t.UTC()
+	return model.UsageDay(time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC))
+}
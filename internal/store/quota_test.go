@@ -0,0 +1,62 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hanxi/tracely/internal/config"
+)
+
+// simulateMinute 在指定分钟内上报 n 次，返回被判定为突增的次数
+func simulateMinute(q *quotaState, key usageKey, minute time.Time, n int) int {
+	spiked := 0
+	for i := 0; i < n; i++ {
+		if q.spiking(key, minute.Add(time.Duration(i)*time.Minute/time.Duration(n))) {
+			spiked++
+		}
+	}
+	return spiked
+}
+
+func TestSpikeProtectionSustainedFlood(t *testing.T) {
+	q := &quotaState{
+		spike: config.SpikeProtection{
+			Enabled:         true,
+			Multiplier:      10,
+			MinPerMinute:    600,
+			BaselineMinutes: 60,
+			SampleRate:      0.1,
+		},
+		windows: make(map[usageKey]*spikeWindow),
+	}
+	key := usageKey{"app", "error"}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	minute := func(i int) time.Time { return start.Add(time.Duration(i) * time.Minute) }
+
+	// 正常流量建立基线
+	const normal = 100
+	for i := 0; i < 120; i++ {
+		if n := simulateMinute(q, key, minute(i), normal); n > 0 {
+			t.Fatalf("minute %d: %d reports flagged under normal traffic", i, n)
+		}
+	}
+
+	// 持续洪泛：每分钟超过阈值（不超过正常流量的 multiplier 倍）的上报都应判定为突增，基线不随洪泛上涨
+	const flood = 20000
+	q.advance(q.windows[key], minute(120))
+	baseline := q.windows[key].baseline
+	for i := 120; i < 180; i++ {
+		if n := simulateMinute(q, key, minute(i), flood); n < flood-10*normal {
+			t.Fatalf("minute %d: only %d of %d reports flagged during flood", i, n, flood)
+		}
+	}
+	q.advance(q.windows[key], minute(180))
+	if got := q.windows[key].baseline; got > baseline {
+		t.Errorf("baseline grew during flood: %.1f -> %.1f", baseline, got)
+	}
+
+	// 洪泛结束后恢复正常
+	if n := simulateMinute(q, key, minute(181), normal); n > 0 {
+		t.Errorf("%d reports flagged after flood ended", n)
+	}
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hanxi/tracely/internal/health"
+	"github.com/hanxi/tracely/internal/model"
+)
+
+// retentionBatch 清理过期事件时每批删除的最大行数
+const retentionBatch = 5000
+
+// PurgeExpiredEvents 按事件定义的保留天数删除过期的原始事件，返回删除的行数
+// 事件名称按 GetEvent 解析到对应的定义（含 glob / regex 定义），未设置保留天数或不在白名单中的事件不删除；
+// 尚未汇总的事件也不删除，汇总表中的统计数据保留
+func (s *Store) PurgeExpiredEvents(now time.Time) (int64, error) {
+	names, err := model.ListEventNames(s.db)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list event names: %w", err)
+	}
+	watermark, err := model.RollupWatermark(s.db)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rollup watermark: %w", err)
+	}
+
+	var total int64
+	for _, name := range names {
+		event, ok := s.GetEvent(name)
+		if !ok || event.RetentionDays <= 0 {
+			continue
+		}
+		cutoff := now.AddDate(0, 0, -event.RetentionDays)
+		if cutoff.After(watermark) {
+			cutoff = watermark
+		}
+		n, err := model.DeleteEventsBefore(s.db, name, cutoff, retentionBatch)
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("failed to purge event %s: %w", name, err)
+		}
+	}
+	return total, nil
+}
+
+// StartRetentionWorker 启动定时清理过期事件
+func (s *Store) StartRetentionWorker(interval time.Duration) {
+	health.Register("event-retention", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			health.Beat("event-retention")
+			n, err := s.PurgeExpiredEvents(time.Now())
+			if err != nil {
+				fmt.Printf("[Tracely] %v\n", err)
+			}
+			if n > 0 {
+				fmt.Printf("[Tracely] Purged %d expired events\n", n)
+			}
+		}
+	}()
+}
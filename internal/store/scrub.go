@@ -0,0 +1,88 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/hanxi/tracely/internal/config"
+	"github.com/hanxi/tracely/internal/model"
+	"github.com/hanxi/tracely/internal/scrub"
+)
+
+// scrubState 全局脱敏规则和按应用编译好的脱敏器
+type scrubState struct {
+	mu     sync.RWMutex
+	global config.Scrub
+	byApp  map[string]*scrub.Scrubber
+}
+
+// SetScrubRules 设置全局脱敏规则并重新编译各应用的脱敏器
+func (s *Store) SetScrubRules(cfg config.Scrub) error {
+	if _, err := scrub.Compile(scrubRules(cfg, model.AppScrub{})); err != nil {
+		return fmt.Errorf("invalid scrub rules: %w", err)
+	}
+	s.scrub.mu.Lock()
+	s.scrub.global = cfg
+	s.scrub.mu.Unlock()
+
+	s.rebuildScrubbers()
+	return nil
+}
+
+// ValidateAppScrub 检查应用的脱敏规则覆盖能否与全局规则合并编译
+func (s *Store) ValidateAppScrub(override model.AppScrub) error {
+	s.scrub.mu.RLock()
+	global := s.scrub.global
+	s.scrub.mu.RUnlock()
+
+	_, err := scrub.Compile(scrubRules(global, override))
+	return err
+}
+
+// Scrubber 获取应用的脱敏器，nil 表示不脱敏
+func (s *Store) Scrubber(appID string) *scrub.Scrubber {
+	s.scrub.mu.RLock()
+	defer s.scrub.mu.RUnlock()
+	return s.scrub.byApp[appID]
+}
+
+// rebuildScrubbers 按当前应用列表重新编译脱敏器（应用规则无效时沿用全局规则）
+func (s *Store) rebuildScrubbers() {
+	apps := s.Apps()
+
+	s.scrub.mu.Lock()
+	defer s.scrub.mu.Unlock()
+
+	byApp := make(map[string]*scrub.Scrubber, len(apps))
+	for _, app := range apps {
+		scrubber, err := scrub.Compile(scrubRules(s.scrub.global, app.Scrub))
+		if err != nil {
+			fmt.Printf("[Tracely] Warning: invalid scrub rules for app %s, using global rules: %v\n", app.AppID, err)
+			scrubber, _ = scrub.Compile(scrubRules(s.scrub.global, model.AppScrub{}))
+		}
+		byApp[app.AppID] = scrubber
+	}
+	s.scrub.byApp = byApp
+}
+
+// scrubRules 合并全局规则和应用覆盖
+func scrubRules(global config.Scrub, override model.AppScrub) scrub.Rules {
+	enabled := global.Enabled
+	if override.Enabled != nil {
+		enabled = *override.Enabled
+	}
+	if !enabled {
+		return scrub.Rules{}
+	}
+
+	rules := scrub.Rules{
+		Detectors:   global.Detectors,
+		Patterns:    append(append([]string{}, global.Patterns...), override.Patterns...),
+		DenyKeys:    append(append([]string{}, global.DenyKeys...), override.DenyKeys...),
+		Replacement: global.Replacement,
+	}
+	if override.Detectors != nil {
+		rules.Detectors = *override.Detectors
+	}
+	return rules
+}
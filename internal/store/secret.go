@@ -0,0 +1,189 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hanxi/tracely/internal/health"
+	"github.com/hanxi/tracely/internal/model"
+	"gorm.io/gorm"
+)
+
+// ErrLastSecret 吊销后应用将没有可用密钥
+var ErrLastSecret = errors.New("至少需要保留一个有效密钥")
+
+// secretUsage 尚未写入数据库的密钥使用计数
+type secretUsage struct {
+	count    int64
+	lastUsed time.Time
+}
+
+// SecretInput 添加/修改密钥参数
+type SecretInput struct {
+	Name      string
+	ExpiresAt *time.Time // 为空表示永不过期
+}
+
+// ActiveSecrets 获取应用当前有效的密钥（签名验证时逐个尝试）
+func (s *Store) ActiveSecrets(appID string) []model.AppSecret {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	var active []model.AppSecret
+	for _, secret := range s.secrets[appID] {
+		if secret.IsActive(now) {
+			active = append(active, secret)
+		}
+	}
+	return active
+}
+
+// RecordSecretUse 记录一次密钥使用（内存计数，定期批量写入数据库）
+func (s *Store) RecordSecretUse(id uint) {
+	s.usageMu.Lock()
+	defer s.usageMu.Unlock()
+
+	u, ok := s.usage[id]
+	if !ok {
+		u = &secretUsage{}
+		s.usage[id] = u
+	}
+	u.count++
+	u.lastUsed = time.Now()
+}
+
+// FlushSecretUsage 将内存中的密钥使用计数在一个事务中写入数据库，失败时放回内存等待下次写入
+func (s *Store) FlushSecretUsage() error {
+	s.usageMu.Lock()
+	pending := s.usage
+	s.usage = make(map[uint]*secretUsage)
+	s.usageMu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for id, u := range pending {
+			if err := model.AddAppSecretUsage(tx, id, u.count, u.lastUsed); err != nil {
+				return fmt.Errorf("failed to flush usage of secret %d: %w", id, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.usageMu.Lock()
+		for id, u := range pending {
+			if cur, ok := s.usage[id]; ok {
+				cur.count += u.count
+			} else {
+				s.usage[id] = u
+			}
+		}
+		s.usageMu.Unlock()
+	}
+	return err
+}
+
+// StartSecretUsageFlusher 启动定时写入密钥使用计数
+func (s *Store) StartSecretUsageFlusher(interval time.Duration) {
+	health.Register("secret-usage-flusher", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			health.Beat("secret-usage-flusher")
+			if err := s.FlushSecretUsage(); err != nil {
+				fmt.Printf("[Tracely] %v\n", err)
+			}
+		}
+	}()
+}
+
+// AppSecrets 获取应用的全部密钥及使用情况（含已过期和已吊销）
+func (s *Store) AppSecrets(appID string) ([]model.AppSecret, error) {
+	if _, ok := s.GetApp(appID); !ok {
+		return nil, ErrNotFound
+	}
+	if err := s.FlushSecretUsage(); err != nil {
+		return nil, err
+	}
+
+	var secrets []model.AppSecret
+	err := s.db.Where("app_id = ?", appID).Order("id ASC").Find(&secrets).Error
+	return secrets, err
+}
+
+// AddAppSecret 为应用添加新密钥，旧密钥在吊销或过期前继续有效
+func (s *Store) AddAppSecret(appID string, input SecretInput) (*model.AppSecret, error) {
+	if _, ok := s.GetApp(appID); !ok {
+		return nil, ErrNotFound
+	}
+	value, err := GenerateSecret(32)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := model.AppSecret{AppID: appID, Name: input.Name, Secret: value, ExpiresAt: input.ExpiresAt}
+	if err := s.mutate(func(tx *gorm.DB) error { return tx.Create(&secret).Error }); err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
+
+// UpdateAppSecret 修改密钥备注和过期时间（用于给旧密钥设置淘汰期限）
+func (s *Store) UpdateAppSecret(appID string, id uint, input SecretInput) (*model.AppSecret, error) {
+	var secret model.AppSecret
+	err := s.mutate(func(tx *gorm.DB) error {
+		if err := tx.Where("app_id = ? AND id = ?", appID, id).First(&secret).Error; err != nil {
+			return notFound(err)
+		}
+		if input.Name != "" {
+			secret.Name = input.Name
+		}
+		secret.ExpiresAt = input.ExpiresAt
+		return tx.Save(&secret).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
+
+// RevokeAppSecret 吊销密钥（不允许吊销应用最后一个有效密钥）
+func (s *Store) RevokeAppSecret(appID string, id uint) (*model.AppSecret, error) {
+	var secret model.AppSecret
+	err := s.mutate(func(tx *gorm.DB) error {
+		if err := tx.Where("app_id = ? AND id = ?", appID, id).First(&secret).Error; err != nil {
+			return notFound(err)
+		}
+		if secret.RevokedAt != nil {
+			return nil
+		}
+
+		now := time.Now()
+		var others []model.AppSecret
+		if err := tx.Where("app_id = ? AND id <> ? AND revoked_at IS NULL", appID, id).Find(&others).Error; err != nil {
+			return err
+		}
+		hasActive := false
+		for _, other := range others {
+			if other.IsActive(now) {
+				hasActive = true
+				break
+			}
+		}
+		if !hasActive {
+			return ErrLastSecret
+		}
+
+		secret.RevokedAt = &now
+		return tx.Save(&secret).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
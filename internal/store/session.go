@@ -0,0 +1,242 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hanxi/tracely/internal/health"
+	"github.com/hanxi/tracely/internal/model"
+	"gorm.io/gorm"
+)
+
+// ErrTokenReused 已轮换的 Refresh Token 被再次使用（可能已泄露），会话已吊销
+var ErrTokenReused = errors.New("Refresh Token 已失效，请重新登录")
+
+// SessionInput 创建/刷新会话参数
+type SessionInput struct {
+	Username        string
+	UserAgent       string
+	IP              string
+	ExpiresAt       time.Time // 会话（Refresh Token）过期时间，刷新时不变
+	AccessJTI       string
+	AccessExpiresAt time.Time
+}
+
+// NewSessionID 生成会话 ID / Access Token jti
+func NewSessionID() (string, error) {
+	return GenerateSecret(16)
+}
+
+// CreateSession 创建登录会话，返回会话和明文 Refresh Token
+func (s *Store) CreateSession(input SessionInput) (*model.Session, string, error) {
+	sid, err := NewSessionID()
+	if err != nil {
+		return nil, "", err
+	}
+	refresh, err := GenerateSecret(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	session := model.Session{
+		SessionID:       sid,
+		Username:        input.Username,
+		RefreshHash:     hashAPIToken(refresh),
+		AccessJTI:       input.AccessJTI,
+		AccessExpiresAt: input.AccessExpiresAt,
+		UserAgent:       input.UserAgent,
+		IP:              input.IP,
+		ExpiresAt:       input.ExpiresAt,
+		LastUsedAt:      now,
+	}
+	if err := s.db.Create(&session).Error; err != nil {
+		return nil, "", err
+	}
+	return &session, refresh, nil
+}
+
+// RotateSession 使用 Refresh Token 换取新的 Refresh Token，旧 Token 立即失效
+// next 返回新 Access Token 的 jti 和过期时间（可据会话信息计算）
+// 已轮换的旧 Token 再次出现时吊销整个会话
+func (s *Store) RotateSession(refresh string, next func(*model.Session) (string, time.Time)) (*model.Session, string, error) {
+	hash := hashAPIToken(refresh)
+	newRefresh, err := GenerateSecret(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var session model.Session
+	var reused bool
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("refresh_hash = ?", hash).First(&session).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := tx.Where("prev_refresh_hash = ?", hash).First(&session).Error; err != nil {
+				return notFound(err)
+			}
+			reused = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if !session.IsActive(now) {
+			return ErrInvalidToken
+		}
+		session.PrevRefreshHash = session.RefreshHash
+		session.RefreshHash = hashAPIToken(newRefresh)
+		session.AccessJTI, session.AccessExpiresAt = next(&session)
+		session.LastUsedAt = now
+		return tx.Save(&session).Error
+	})
+	if errors.Is(err, ErrNotFound) {
+		return nil, "", ErrInvalidToken
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if reused {
+		if session.RevokedAt == nil {
+			fmt.Printf("[Tracely] Refresh token reuse detected for user %s, revoking session %s\n", session.Username, session.SessionID)
+			if err := s.RevokeSession(session.SessionID); err != nil {
+				return nil, "", err
+			}
+		}
+		return nil, "", ErrTokenReused
+	}
+	return &session, newRefresh, nil
+}
+
+// RevokeSession 吊销会话，该会话签发的 Access Token 同时失效
+func (s *Store) RevokeSession(sessionID string) error {
+	var session model.Session
+	if err := s.db.Where("session_id = ?", sessionID).First(&session).Error; err != nil {
+		return notFound(err)
+	}
+	return s.revokeSessions([]model.Session{session})
+}
+
+// RevokeUserSessions 吊销用户的全部会话，返回吊销数量
+func (s *Store) RevokeUserSessions(username string) (int, error) {
+	var sessions []model.Session
+	err := s.db.Where("username = ? AND revoked_at IS NULL AND expires_at > ?", username, time.Now()).Find(&sessions).Error
+	if err != nil {
+		return 0, err
+	}
+	return len(sessions), s.revokeSessions(sessions)
+}
+
+// revokeSessions 标记会话已吊销并加入内存吊销列表
+func (s *Store) revokeSessions(sessions []model.Session) error {
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	ids := make([]uint, 0, len(sessions))
+	for _, session := range sessions {
+		ids = append(ids, session.ID)
+	}
+	err := s.db.Model(&model.Session{}).Where("id IN ? AND revoked_at IS NULL", ids).Update("revoked_at", now).Error
+	if err != nil {
+		return err
+	}
+
+	s.revokeMu.Lock()
+	for _, session := range sessions {
+		s.revokedSessions[session.SessionID] = session.AccessExpiresAt
+	}
+	s.revokeMu.Unlock()
+	return nil
+}
+
+// RevokeAccessToken 将 Access Token 的 jti 加入吊销列表，直到其过期
+func (s *Store) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	token := model.RevokedToken{JTI: jti, ExpiresAt: expiresAt}
+	if err := s.db.Save(&token).Error; err != nil {
+		return err
+	}
+
+	s.revokeMu.Lock()
+	s.revokedJTIs[jti] = expiresAt
+	s.revokeMu.Unlock()
+	return nil
+}
+
+// IsAccessRevoked 检查 Access Token 是否已吊销（jti 在吊销列表中或所属会话已吊销）
+func (s *Store) IsAccessRevoked(jti, sessionID string) bool {
+	s.revokeMu.RLock()
+	defer s.revokeMu.RUnlock()
+
+	if _, ok := s.revokedJTIs[jti]; ok {
+		return true
+	}
+	_, ok := s.revokedSessions[sessionID]
+	return ok
+}
+
+// ListUserSessions 获取用户的会话列表
+func (s *Store) ListUserSessions(username string) ([]model.Session, error) {
+	if _, ok := s.GetUser(username); !ok {
+		return nil, ErrNotFound
+	}
+	return model.ListUserSessions(s.db, username)
+}
+
+// loadRevocations 从数据库加载仍在有效期内的吊销记录
+func (s *Store) loadRevocations() error {
+	now := time.Now()
+
+	var tokens []model.RevokedToken
+	if err := s.db.Where("expires_at > ?", now).Find(&tokens).Error; err != nil {
+		return fmt.Errorf("failed to load revoked tokens: %w", err)
+	}
+	var sessions []model.Session
+	if err := s.db.Where("revoked_at IS NOT NULL AND access_expires_at > ?", now).Find(&sessions).Error; err != nil {
+		return fmt.Errorf("failed to load revoked sessions: %w", err)
+	}
+
+	s.revokeMu.Lock()
+	defer s.revokeMu.Unlock()
+	for _, token := range tokens {
+		s.revokedJTIs[token.JTI] = token.ExpiresAt
+	}
+	for _, session := range sessions {
+		s.revokedSessions[session.SessionID] = session.AccessExpiresAt
+	}
+	return nil
+}
+
+// StartSessionCleaner 启动定时清理过期会话和吊销记录
+func (s *Store) StartSessionCleaner(interval time.Duration) {
+	health.Register("session-cleaner", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			health.Beat("session-cleaner")
+			now := time.Now()
+			if err := model.DeleteExpiredSessions(s.db, now); err != nil {
+				fmt.Printf("[Tracely] Failed to clean sessions: %v\n", err)
+			}
+
+			s.revokeMu.Lock()
+			for jti, expiresAt := range s.revokedJTIs {
+				if now.After(expiresAt) {
+					delete(s.revokedJTIs, jti)
+				}
+			}
+			for sid, expiresAt := range s.revokedSessions {
+				if now.After(expiresAt) {
+					delete(s.revokedSessions, sid)
+				}
+			}
+			s.revokeMu.Unlock()
+		}
+	}()
+}
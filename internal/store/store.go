@@ -0,0 +1,297 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hanxi/tracely/internal/config"
+	"github.com/hanxi/tracely/internal/model"
+	"gorm.io/gorm"
+)
+
+// 管理操作的业务错误
+var (
+	ErrNotFound  = errors.New("记录不存在")
+	ErrConflict  = errors.New("记录已存在")
+	ErrLastAdmin = errors.New("至少需要保留一个管理员")
+)
+
+// Store 应用、用户和事件白名单的数据库存储 + 内存缓存
+// 数据以数据库为准（首次启动时从 config.yaml 导入），每次变更后重新加载缓存
+type Store struct {
+	db *gorm.DB
+
+	mu            sync.RWMutex
+	apps          map[string]model.App
+	secrets       map[string][]model.AppSecret // AppID -> 密钥
+	users         map[string]model.User
+	events        map[string]model.EventDefinition // exact 事件名称 -> 事件定义
+	eventPatterns []eventPattern                   // glob / regex 事件（按创建顺序匹配）
+
+	usageMu sync.Mutex
+	usage   map[uint]*secretUsage // 密钥 ID -> 待写入的使用计数
+
+	revokeMu        sync.RWMutex
+	revokedJTIs     map[string]time.Time // 已吊销的 Access Token jti -> 过期时间
+	revokedSessions map[string]time.Time // 已吊销的会话 ID -> 最后一个 Access Token 过期时间
+
+	mfa mfaChallenges
+
+	quota quotaState
+	scrub scrubState
+
+	validationMu sync.Mutex
+	validation   map[string]*validationFailure // 事件名 -> 待写入的 metadata 校验失败计数
+
+	discovery discoveryState
+}
+
+// New 创建 Store 并加载缓存
+func New(db *gorm.DB) (*Store, error) {
+	s := &Store{
+		db:         db,
+		usage:      make(map[uint]*secretUsage),
+		validation: make(map[string]*validationFailure),
+		discovery: discoveryState{
+			known:   make(map[string]bool),
+			pending: make(map[string]*model.PendingEvent),
+		},
+		revokedJTIs:     make(map[string]time.Time),
+		revokedSessions: make(map[string]time.Time),
+		mfa:             mfaChallenges{items: make(map[string]*mfaChallenge)},
+		quota: quotaState{
+			pending: make(map[usageDayKey]*model.AppUsage),
+			windows: make(map[usageKey]*spikeWindow),
+		},
+	}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	if err := s.loadRevocations(); err != nil {
+		return nil, err
+	}
+	if err := s.loadUsage(time.Now()); err != nil {
+		return nil, err
+	}
+	if err := s.loadPendingEvents(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload 从数据库重新加载缓存
+func (s *Store) Reload() error {
+	apps, err := model.ListApps(s.db)
+	if err != nil {
+		return fmt.Errorf("failed to load apps: %w", err)
+	}
+	secrets, err := model.ListAppSecrets(s.db)
+	if err != nil {
+		return fmt.Errorf("failed to load app secrets: %w", err)
+	}
+	users, err := model.ListUsers(s.db)
+	if err != nil {
+		return fmt.Errorf("failed to load users: %w", err)
+	}
+	events, err := model.ListEventDefinitions(s.db)
+	if err != nil {
+		return fmt.Errorf("failed to load events: %w", err)
+	}
+
+	appMap := make(map[string]model.App, len(apps))
+	for _, app := range apps {
+		appMap[app.AppID] = app
+	}
+	secretMap := make(map[string][]model.AppSecret, len(apps))
+	for _, secret := range secrets {
+		secretMap[secret.AppID] = append(secretMap[secret.AppID], secret)
+	}
+	userMap := make(map[string]model.User, len(users))
+	for _, user := range users {
+		userMap[user.Username] = user
+	}
+	eventMap, patterns := buildEventMatcher(events)
+
+	s.mu.Lock()
+	s.apps, s.secrets, s.users, s.events, s.eventPatterns = appMap, secretMap, userMap, eventMap, patterns
+	s.mu.Unlock()
+
+	s.rebuildScrubbers()
+	return nil
+}
+
+// Seed 首次启动时将 config.yaml 中的应用、用户和事件导入数据库（各表为空时才导入）
+func (s *Store) Seed(cfg *config.Config) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+
+		if err := tx.Model(&model.App{}).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			for _, app := range cfg.Apps {
+				origins, invalid, ok := config.NormalizeOrigins(app.AllowedOrigins)
+				if !ok {
+					return fmt.Errorf("invalid allowed origin %q for app %s", invalid, app.AppID)
+				}
+				record := model.App{
+					AppID:          app.AppID,
+					AppName:        app.AppName,
+					AllowedOrigins: origins,
+					Quota:          model.AppQuota(app.Quota),
+					Scrub:          model.AppScrub(app.Scrub),
+				}
+				if err := tx.Create(&record).Error; err != nil {
+					return err
+				}
+				secret := model.AppSecret{AppID: app.AppID, Name: "default", Secret: app.AppSecret}
+				if err := tx.Create(&secret).Error; err != nil {
+					return err
+				}
+			}
+			if len(cfg.Apps) > 0 {
+				fmt.Printf("[Tracely] Seeded %d apps from config\n", len(cfg.Apps))
+			}
+		}
+
+		if err := tx.Model(&model.User{}).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			for _, user := range cfg.Users {
+				record := model.User{
+					Username:     user.Username,
+					PasswordHash: user.PasswordHash,
+					Role:         user.Role,
+					Apps:         user.Apps,
+					TOTPSecret:   user.TOTPSecret,
+					TOTPEnabled:  user.TOTPSecret != "",
+				}
+				if err := tx.Create(&record).Error; err != nil {
+					return err
+				}
+			}
+			if len(cfg.Users) > 0 {
+				fmt.Printf("[Tracely] Seeded %d users from config\n", len(cfg.Users))
+			}
+		}
+
+		if err := tx.Model(&model.EventDefinition{}).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			for _, event := range cfg.Events {
+				record := model.EventDefinition{
+					EventName:     event.EventName,
+					Match:         event.Match,
+					Description:   event.Description,
+					RetentionDays: event.RetentionDays,
+					Schema:        (*model.MetadataSchema)(event.Schema),
+				}
+				if record.Match == "" {
+					record.Match = model.EventMatchExact
+				}
+				if _, err := record.CompilePattern(); err != nil {
+					return fmt.Errorf("invalid pattern for event %s: %w", event.EventName, err)
+				}
+				if record.Schema != nil {
+					if err := record.Schema.Check(); err != nil {
+						return fmt.Errorf("invalid schema for event %s: %w", event.EventName, err)
+					}
+				}
+				if err := tx.Create(&record).Error; err != nil {
+					return err
+				}
+			}
+			if len(cfg.Events) > 0 {
+				fmt.Printf("[Tracely] Seeded %d events from config\n", len(cfg.Events))
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to seed from config: %w", err)
+	}
+
+	if err := s.Reload(); err != nil {
+		return err
+	}
+	s.warnConfigDrift(cfg)
+	return nil
+}
+
+// warnConfigDrift 提示 config.yaml 中存在但数据库中没有的条目（导入后以数据库为准）
+func (s *Store) warnConfigDrift(cfg *config.Config) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, app := range cfg.Apps {
+		if _, ok := s.apps[app.AppID]; !ok {
+			fmt.Printf("[Tracely] Warning: app %s in config is not in database, manage it via /api/admin/apps\n", app.AppID)
+		}
+	}
+	for _, user := range cfg.Users {
+		if _, ok := s.users[user.Username]; !ok {
+			fmt.Printf("[Tracely] Warning: user %s in config is not in database, manage it via /api/admin/users\n", user.Username)
+		}
+	}
+	for _, event := range cfg.Events {
+		if !s.hasEventDefinition(event.EventName) {
+			fmt.Printf("[Tracely] Warning: event %s in config is not in database, manage it via /api/admin/events\n", event.EventName)
+		}
+	}
+}
+
+// GetApp 根据 AppID 获取应用
+func (s *Store) GetApp(appID string) (model.App, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	app, ok := s.apps[appID]
+	return app, ok
+}
+
+// Apps 获取全部应用（按创建顺序）
+func (s *Store) Apps() []model.App {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	apps := make([]model.App, 0, len(s.apps))
+	for _, app := range s.apps {
+		apps = append(apps, app)
+	}
+	sortByID(apps, func(a model.App) uint { return a.ID })
+	return apps
+}
+
+// GetUser 根据用户名获取用户
+func (s *Store) GetUser(username string) (model.User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.users[username]
+	return user, ok
+}
+
+// Users 获取全部用户（按创建顺序）
+func (s *Store) Users() []model.User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	users := make([]model.User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	sortByID(users, func(u model.User) uint { return u.ID })
+	return users
+}
+
+// GenerateSecret 生成 length 字节的随机十六进制字符串
+func GenerateSecret(length int) (string, error) {
+	bytes := make([]byte, length)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
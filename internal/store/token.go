@@ -0,0 +1,119 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/hanxi/tracely/internal/model"
+	"gorm.io/gorm"
+)
+
+// APITokenPrefix API Token 前缀，用于区分 JWT
+const APITokenPrefix = "tly_"
+
+// tokenTouchInterval 最后使用时间的最小更新间隔，避免每个请求都写库
+const tokenTouchInterval = time.Minute
+
+// ErrInvalidToken API Token 不存在、已吊销、已过期或所属用户已删除
+var ErrInvalidToken = errors.New("Token 无效或已过期")
+
+// IsAPIToken 判断字符串是否为 API Token（而非 JWT）
+func IsAPIToken(raw string) bool {
+	return strings.HasPrefix(raw, APITokenPrefix)
+}
+
+// hashAPIToken 计算 Token 哈希（Token 本身为高熵随机串，无需加盐慢哈希）
+func hashAPIToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// APITokenInput 创建 API Token 参数
+type APITokenInput struct {
+	Name      string
+	Scopes    []string
+	Apps      []string
+	ExpiresAt *time.Time
+}
+
+// CreateAPIToken 为用户创建 API Token，返回记录和明文 Token（明文只在此时返回）
+func (s *Store) CreateAPIToken(username string, input APITokenInput) (*model.APIToken, string, error) {
+	if _, ok := s.GetUser(username); !ok {
+		return nil, "", ErrNotFound
+	}
+	value, err := GenerateSecret(32)
+	if err != nil {
+		return nil, "", err
+	}
+	raw := APITokenPrefix + value
+
+	token := model.APIToken{
+		Username:  username,
+		Name:      input.Name,
+		Prefix:    raw[:len(APITokenPrefix)+8],
+		TokenHash: hashAPIToken(raw),
+		Scopes:    input.Scopes,
+		Apps:      input.Apps,
+		ExpiresAt: input.ExpiresAt,
+	}
+	if err := s.db.Create(&token).Error; err != nil {
+		return nil, "", err
+	}
+	return &token, raw, nil
+}
+
+// ListAPITokens 获取用户的全部 API Token
+func (s *Store) ListAPITokens(username string) ([]model.APIToken, error) {
+	return model.ListAPITokens(s.db, username)
+}
+
+// RevokeAPIToken 吊销用户的 API Token
+func (s *Store) RevokeAPIToken(username string, id uint) (*model.APIToken, error) {
+	var token model.APIToken
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("username = ? AND id = ?", username, id).First(&token).Error; err != nil {
+			return notFound(err)
+		}
+		if token.RevokedAt != nil {
+			return nil
+		}
+		now := time.Now()
+		token.RevokedAt = &now
+		return tx.Save(&token).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// AuthenticateAPIToken 校验 API Token，返回 Token 记录和所属用户（用户当前的角色和应用权限）
+func (s *Store) AuthenticateAPIToken(raw string) (*model.APIToken, model.User, error) {
+	token, err := model.GetAPITokenByHash(s.db, hashAPIToken(raw))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, model.User{}, ErrInvalidToken
+		}
+		return nil, model.User{}, err
+	}
+
+	now := time.Now()
+	if !token.IsActive(now) {
+		return nil, model.User{}, ErrInvalidToken
+	}
+	user, ok := s.GetUser(token.Username)
+	if !ok {
+		return nil, model.User{}, ErrInvalidToken
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= tokenTouchInterval {
+		if err := model.TouchAPIToken(s.db, token.ID, now); err != nil {
+			return nil, model.User{}, err
+		}
+		token.LastUsedAt = &now
+	}
+	return token, user, nil
+}
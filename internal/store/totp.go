@@ -0,0 +1,230 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hanxi/tracely/internal/model"
+	"github.com/hanxi/tracely/internal/totp"
+	"gorm.io/gorm"
+)
+
+// 两步验证相关错误
+var (
+	ErrInvalidCode     = errors.New("验证码错误")
+	ErrTOTPNotEnrolled = errors.New("请先获取两步验证密钥")
+	ErrTOTPRequired    = errors.New("管理员要求启用两步验证，不能关闭")
+)
+
+// 两步验证参数
+const (
+	recoveryCodeCount = 10
+	mfaTokenTTL       = 5 * time.Minute // 密码验证通过后完成第二步的时限
+	mfaMaxAttempts    = 5               // 每个 MFA Token 最多尝试次数
+)
+
+// mfaChallenge 密码验证通过、等待第二步验证的登录
+type mfaChallenge struct {
+	username  string
+	expiresAt time.Time
+	attempts  int
+}
+
+// mfaChallenges 进行中的两步验证登录（MFA Token -> 登录信息）
+type mfaChallenges struct {
+	mu    sync.Mutex
+	items map[string]*mfaChallenge
+}
+
+// IssueMFAToken 密码验证通过后签发 MFA Token，用于完成第二步验证或首次绑定
+func (s *Store) IssueMFAToken(username string) (string, error) {
+	token, err := GenerateSecret(32)
+	if err != nil {
+		return "", err
+	}
+
+	s.mfa.mu.Lock()
+	defer s.mfa.mu.Unlock()
+	now := time.Now()
+	for k, c := range s.mfa.items {
+		if now.After(c.expiresAt) {
+			delete(s.mfa.items, k)
+		}
+	}
+	s.mfa.items[token] = &mfaChallenge{username: username, expiresAt: now.Add(mfaTokenTTL)}
+	return token, nil
+}
+
+// MFATokenUser 获取 MFA Token 对应的用户名并计一次尝试，超过次数或过期后失效
+func (s *Store) MFATokenUser(token string) (string, bool) {
+	s.mfa.mu.Lock()
+	defer s.mfa.mu.Unlock()
+
+	c, ok := s.mfa.items[token]
+	if !ok {
+		return "", false
+	}
+	c.attempts++
+	if time.Now().After(c.expiresAt) || c.attempts > mfaMaxAttempts {
+		delete(s.mfa.items, token)
+		return "", false
+	}
+	return c.username, true
+}
+
+// ConsumeMFAToken 第二步验证成功后使 MFA Token 失效
+func (s *Store) ConsumeMFAToken(token string) {
+	s.mfa.mu.Lock()
+	delete(s.mfa.items, token)
+	s.mfa.mu.Unlock()
+}
+
+// BeginTOTPEnrollment 生成新的待确认密钥（已启用时需先关闭）
+func (s *Store) BeginTOTPEnrollment(username string) (string, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+	err = s.mutate(func(tx *gorm.DB) error {
+		var user model.User
+		if err := tx.Where("username = ?", username).First(&user).Error; err != nil {
+			return notFound(err)
+		}
+		if user.TOTPEnabled {
+			return ErrConflict
+		}
+		user.TOTPSecret = secret
+		return tx.Save(&user).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// ActivateTOTP 用认证器 App 中的验证码确认密钥并启用两步验证，返回明文恢复码（只返回一次）
+func (s *Store) ActivateTOTP(username, code string) ([]string, error) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = s.mutate(func(tx *gorm.DB) error {
+		var user model.User
+		if err := tx.Where("username = ?", username).First(&user).Error; err != nil {
+			return notFound(err)
+		}
+		if user.TOTPEnabled {
+			return ErrConflict
+		}
+		if user.TOTPSecret == "" {
+			return ErrTOTPNotEnrolled
+		}
+		step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+		if !ok {
+			return ErrInvalidCode
+		}
+		user.TOTPEnabled = true
+		user.TOTPLastStep = step
+		user.RecoveryCodes = hashes
+		return tx.Save(&user).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifySecondFactor 校验验证码或恢复码（恢复码使用后作废，验证码不能重复使用）
+func (s *Store) VerifySecondFactor(username, code string) error {
+	return s.mutate(func(tx *gorm.DB) error {
+		var user model.User
+		if err := tx.Where("username = ?", username).First(&user).Error; err != nil {
+			return notFound(err)
+		}
+		if !user.TOTPEnabled {
+			return ErrTOTPNotEnrolled
+		}
+
+		if step, ok := totp.Validate(user.TOTPSecret, code, time.Now()); ok {
+			if step <= user.TOTPLastStep {
+				return ErrInvalidCode
+			}
+			user.TOTPLastStep = step
+			return tx.Save(&user).Error
+		}
+
+		hash := hashRecoveryCode(code)
+		for i, h := range user.RecoveryCodes {
+			if h == hash {
+				user.RecoveryCodes = append(user.RecoveryCodes[:i:i], user.RecoveryCodes[i+1:]...)
+				return tx.Save(&user).Error
+			}
+		}
+		return ErrInvalidCode
+	})
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码（旧恢复码全部作废）
+func (s *Store) RegenerateRecoveryCodes(username string) ([]string, error) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = s.mutate(func(tx *gorm.DB) error {
+		var user model.User
+		if err := tx.Where("username = ?", username).First(&user).Error; err != nil {
+			return notFound(err)
+		}
+		if !user.TOTPEnabled {
+			return ErrTOTPNotEnrolled
+		}
+		user.RecoveryCodes = hashes
+		return tx.Save(&user).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP 关闭两步验证，force 为 true 时忽略管理员的强制要求（管理员重置）
+func (s *Store) DisableTOTP(username string, force bool) error {
+	return s.mutate(func(tx *gorm.DB) error {
+		var user model.User
+		if err := tx.Where("username = ?", username).First(&user).Error; err != nil {
+			return notFound(err)
+		}
+		if user.TOTPRequired && !force {
+			return ErrTOTPRequired
+		}
+		user.TOTPEnabled = false
+		user.TOTPSecret = ""
+		user.TOTPLastStep = 0
+		user.RecoveryCodes = nil
+		return tx.Save(&user).Error
+	})
+}
+
+// generateRecoveryCodes 生成恢复码及其哈希，格式如 1a2b3-c4d5e
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw, err := GenerateSecret(5)
+		if err != nil {
+			return nil, nil, err
+		}
+		code := raw[:5] + "-" + raw[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode 计算恢复码哈希（忽略大小写、空格和连字符）
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return hashAPIToken(code)
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/hanxi/tracely/internal/health"
+	"github.com/hanxi/tracely/internal/model"
+	"gorm.io/gorm"
+)
+
+// validationFailure 尚未写入数据库的事件 metadata 校验失败计数
+type validationFailure struct {
+	count   int64
+	lastErr string
+	lastAt  time.Time
+}
+
+// RecordValidationFailure 记录一次事件 metadata 校验失败（内存计数，定期批量写入数据库）
+func (s *Store) RecordValidationFailure(eventName, reason string) {
+	s.validationMu.Lock()
+	defer s.validationMu.Unlock()
+
+	f, ok := s.validation[eventName]
+	if !ok {
+		f = &validationFailure{}
+		s.validation[eventName] = f
+	}
+	f.count++
+	f.lastErr = reason
+	f.lastAt = time.Now()
+}
+
+// FlushValidationFailures 将内存中的校验失败计数在一个事务中写入数据库，并按事件输出一条汇总日志
+// 写入失败时放回内存等待下次写入
+func (s *Store) FlushValidationFailures() error {
+	s.validationMu.Lock()
+	pending := s.validation
+	s.validation = make(map[string]*validationFailure)
+	s.validationMu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for eventName, f := range pending {
+			if err := model.AddEventValidationFailures(tx, eventName, f.count, f.lastErr, f.lastAt); err != nil {
+				return fmt.Errorf("failed to flush validation failures of event %s: %w", eventName, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.validationMu.Lock()
+		for eventName, f := range pending {
+			if cur, ok := s.validation[eventName]; ok {
+				cur.count += f.count
+			} else {
+				s.validation[eventName] = f
+			}
+		}
+		s.validationMu.Unlock()
+		return err
+	}
+
+	for eventName, f := range pending {
+		slog.Warn("[Tracely] Event metadata validation failed", "event", eventName, "count", f.count, "last", f.lastErr)
+	}
+	return nil
+}
+
+// StartValidationFlusher 启动定时写入校验失败计数
+func (s *Store) StartValidationFlusher(interval time.Duration) {
+	health.Register("event-validation-flusher", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			health.Beat("event-validation-flusher")
+			if err := s.FlushValidationFailures(); err != nil {
+				fmt.Printf("[Tracely] %v\n", err)
+			}
+		}
+	}()
+}
+
+// ListEvents 获取全部事件定义及最新的校验失败计数（从数据库读取）
+func (s *Store) ListEvents() ([]model.EventDefinition, error) {
+	if err := s.FlushValidationFailures(); err != nil {
+		return nil, err
+	}
+	return model.ListEventDefinitions(s.db)
+}
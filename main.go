@@ -1,20 +1,30 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
+	"errors"
 	"flag"
 	"fmt"
 	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hanxi/tracely/dashboard"
 	"github.com/hanxi/tracely/internal/config"
 	"github.com/hanxi/tracely/internal/handler"
+	"github.com/hanxi/tracely/internal/ingest"
+	"github.com/hanxi/tracely/internal/metrics"
 	"github.com/hanxi/tracely/internal/middleware"
 	"github.com/hanxi/tracely/internal/model"
+	"github.com/hanxi/tracely/internal/oidc"
+	"github.com/hanxi/tracely/internal/store"
+	"github.com/hanxi/tracely/internal/totp"
 	"github.com/hanxi/tracely/internal/version"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -25,6 +35,8 @@ func main() {
 	hashpwdMode := flag.Bool("hashpwd", false, "密码哈希模式")
 	password := flag.String("password", "", "要哈希的密码（与 -hashpwd 一起使用）")
 	generateSecret := flag.Bool("generate-secret", false, "生成随机 Secret")
+	totpMode := flag.Bool("totp", false, "生成两步验证密钥（写入 config.yaml，仅在首次初始化用户时生效）")
+	username := flag.String("username", "", "用户名（与 -totp 一起使用）")
 	secretLength := flag.Int("secret-length", 32, "生成 Secret 的长度（与 -generate-secret 一起使用）")
 	showVersion := flag.Bool("version", false, "显示版本信息")
 	showVersionShort := flag.Bool("v", false, "显示版本信息（简写）")
@@ -64,6 +76,26 @@ func main() {
 		return
 	}
 
+	// 两步验证密钥模式
+	if *totpMode {
+		if *username == "" {
+			fmt.Println("Usage: ./tracely -totp -username <username>")
+			os.Exit(1)
+		}
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			fmt.Printf("Error generating TOTP secret: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("TOTP secret for '%s':\n%s\n", *username, secret)
+		fmt.Printf("\notpauth URI (scan with an authenticator app):\n%s\n", totp.URI("Tracely", *username, secret))
+		fmt.Println("\nCopy this secret to config.yaml users[].totpSecret field.")
+		fmt.Println("It only takes effect when users are seeded from config (the database has no users yet).")
+		fmt.Println("For existing users, enable two-factor authentication after login (POST /api/account/totp/setup),")
+		fmt.Println("or have an admin set totpRequired (PUT /api/admin/users/" + *username + ") to enroll on next login.")
+		return
+	}
+
 	// 服务器模式（默认）
 	if !*serverMode && !*hashpwdMode && !*generateSecret {
 		// 默认启动服务器
@@ -86,6 +118,7 @@ func main() {
 	fmt.Println("  ./tracely -version           # 显示版本信息")
 	fmt.Println("  ./tracely -hashpwd -password <password>  # 生成密码哈希")
 	fmt.Println("  ./tracely -generate-secret [-secret-length 32]  # 生成随机 Secret")
+	fmt.Println("  ./tracely -totp -username <username>  # 生成两步验证密钥（首次初始化用户时使用）")
 	fmt.Println()
 	fmt.Println("Options:")
 	flag.PrintDefaults()
@@ -99,8 +132,12 @@ func printVersion() {
 	fmt.Printf("Go version: %s\n", version.GoVersion)
 }
 
+// shutdownTimeout 优雅退出时等待请求处理完毕和写入队列清空的最长时间
+const shutdownTimeout = 15 * time.Second
+
 func runServer() {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
 	logger.Info("[Tracely] Starting server...")
 
 	// 1. 加载配置
@@ -110,58 +147,165 @@ func runServer() {
 		os.Exit(1)
 	}
 
-	// 2. 初始化数据库
+	// 2. 初始化数据库，首次启动时从 config.yaml 导入应用、用户和事件白名单
 	db, err := model.InitDB(cfg.DBPath)
 	if err != nil {
 		logger.Error("[Tracely] Failed to initialize database", "error", err)
 		os.Exit(1)
 	}
+	st, err := store.New(db)
+	if err != nil {
+		logger.Error("[Tracely] Failed to load store", "error", err)
+		os.Exit(1)
+	}
+	if err := st.Seed(cfg); err != nil {
+		logger.Error("[Tracely] Failed to seed store", "error", err)
+		os.Exit(1)
+	}
+
+	// 上报处理规则：突增保护和写入前脱敏
+	st.SetSpikeProtection(cfg.SpikeProtection)
+	st.SetEventDiscovery(cfg.EventDiscovery)
+	if err := st.SetScrubRules(cfg.Scrub); err != nil {
+		logger.Error("[Tracely] Failed to load scrub rules", "error", err)
+		os.Exit(1)
+	}
+
+	// 3. 启动后台任务：Nonce 清理、密钥使用计数和应用用量写入、过期会话清理、事件汇总（Dashboard 统计优先读取汇总表）、过期事件清理
+	middleware.StartNonceCleaner()
+	st.StartSecretUsageFlusher(time.Minute)
+	st.StartSessionCleaner(time.Hour)
+	st.StartAppUsageFlusher(time.Minute)
+	st.StartValidationFlusher(time.Minute)
+	st.StartPendingEventFlusher(time.Minute)
+	model.StartRollupWorker(db, cfg.RollupInterval)
+	st.StartRetentionWorker(time.Hour)
 
-	// 3. 启动 Nonce 清理任务
-	middleware.StartNonceCleaner(cfg.NonceTTL)
+	// 上报写入队列：校验通过即返回，由写入协程批量写入数据库，退出时写完队列中剩余数据
+	queue := ingest.New(db, cfg.Ingest)
+	queue.Start()
 
 	// 4. 创建 Gin 实例
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
+	// 只信任配置的反向代理转发的 X-Forwarded-For，否则客户端可伪造 IP 绕过登录保护和按 IP 限速
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		logger.Error("[Tracely] Invalid trusted proxies", "error", err)
+		os.Exit(1)
+	}
 
-	// 跨域中间件
-	r.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization, X-App-Id, X-Timestamp, X-Nonce, X-Signature")
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusNoContent)
-			return
-		}
-		c.Next()
-	})
+	// 跨域中间件：Dashboard 接口只允许同源和 dashboardOrigins，上报接口按应用的 allowedOrigins 限制
+	r.Use(middleware.CORS(cfg.DashboardOrigins, st))
 
 	// 6. 注册路由
-	// 登录接口（无需认证）
-	r.POST("/auth/login", handler.Login(cfg))
+	// 健康检查（无需认证，供 Docker HEALTHCHECK 和编排系统探测）
+	r.GET("/healthz", handler.Healthz())
+	r.GET("/readyz", handler.Readyz(db))
 
-	// API 接口组（JWT 验证，Dashboard 调用）
+	// 认证接口（登录、两步验证、刷新无需认证，退出需携带 Access Token）
+	// 登录按用户名和 IP 统计连续失败次数，超过阈值后退避或临时锁定
+	loginGuard := middleware.NewLoginGuard(cfg.LoginGuard)
+	loginGuard.StartCleaner()
+	r.POST("/auth/login", handler.Login(st, loginGuard))
+	r.POST("/auth/login/totp", handler.LoginTOTP(st, loginGuard))
+	r.POST("/auth/totp/setup", handler.LoginTOTPSetup(st))
+	r.POST("/auth/totp/activate", handler.LoginTOTPActivate(st, loginGuard))
+	r.POST("/auth/refresh", handler.Refresh(st))
+	r.POST("/auth/logout", middleware.JWTAuth(cfg.JWT.Secret, st), handler.Logout(st))
+	r.GET("/auth/config", handler.AuthConfig())
+
+	// OIDC 单点登录（授权码 + PKCE）
+	if cfg.OIDC.Enabled {
+		provider := oidc.NewProvider(cfg.OIDC)
+		r.GET("/auth/oidc/login", handler.OIDCLogin(provider))
+		r.GET("/auth/oidc/callback", handler.OIDCCallback(st, provider))
+		r.POST("/auth/oidc/token", handler.OIDCToken(st, provider))
+	}
+
+	// API 接口组（JWT / API Token 验证 + 角色/应用权限，Dashboard 和脚本调用）
 	api := r.Group("/api")
-	api.Use(middleware.JWTAuth(cfg.JWT.Secret))
+	api.Use(middleware.JWTAuth(cfg.JWT.Secret, st), middleware.Authorize())
 	{
-		api.GET("/apps", handler.GetApps(cfg))                             // 应用列表
-		api.GET("/overview", handler.Overview(db))                         // 概览数据
-		api.GET("/errors", handler.ErrorList(db))                          // 错误列表
-		api.GET("/events/stats", handler.GetEventStats(db))                // 事件统计
-		api.GET("/events/top", handler.GetTopEvents(db))                   // Top 事件
-		api.GET("/events/daily", handler.GetDailyEvents(db))               // 每日事件统计
-		api.GET("/events/overview", handler.GetEventOverview(db))          // 事件概览
-		api.GET("/events/list", handler.GetEventList(db))                  // 事件列表
-		api.GET("/events/stats/summary", handler.GetEventStatsSummary(db)) // 事件统计摘要
+		api.GET("/apps", handler.GetApps(st))                                                                                   // 应用列表
+		api.GET("/apps/:appId/usage", handler.GetAppUsage(st))                                                                  // 应用配额和用量
+		api.GET("/overview", handler.Overview(db))                                                                              // 概览数据
+		api.GET("/errors", handler.AuditDataRead(st, model.AuditDataErrorList), handler.ErrorList(db))                          // 错误列表
+		api.GET("/events/stats", handler.GetEventStats(db))                                                                     // 事件统计
+		api.GET("/events/top", handler.GetTopEvents(db))                                                                        // Top 事件
+		api.GET("/events/daily", handler.GetDailyEvents(db))                                                                    // 每日事件统计
+		api.GET("/events/overview", handler.GetEventOverview(db))                                                               // 事件概览
+		api.GET("/events/list", handler.AuditDataRead(st, model.AuditDataEventList), handler.GetEventList(db))                  // 事件列表
+		api.GET("/events/stats/summary", handler.GetEventStatsSummary(db))                                                      // 事件统计摘要
+		api.GET("/events/paths", handler.GetEventPaths(db))                                                                     // 路径分析
+		api.GET("/active/users", handler.GetActiveUsers(db))                                                                    // DAU/WAU/MAU 及粘性
+		api.GET("/active/instances", handler.GetActiveInstances(db))                                                            // 实例在线趋势
+		api.GET("/users/:userId/timeline", handler.AuditDataRead(st, model.AuditDataUserTimeline), handler.GetUserTimeline(db)) // 用户时间线
+		api.GET("/tokens", handler.ListAPITokens(st))                                                                           // 个人 API Token 列表
+		api.POST("/tokens", handler.CreateAPIToken(st))                                                                         // 创建 API Token
+		api.DELETE("/tokens/:tokenId", handler.RevokeAPIToken(st))                                                              // 吊销 API Token
+		api.GET("/account/totp", handler.GetTOTPStatus(st))                                                                     // 两步验证状态
+		api.POST("/account/totp/setup", handler.SetupTOTP(st))                                                                  // 获取两步验证密钥
+		api.POST("/account/totp/activate", handler.ActivateTOTP(st))                                                            // 确认启用两步验证
+		api.DELETE("/account/totp", handler.DisableTOTP(st, loginGuard))                                                        // 关闭两步验证
+		api.POST("/account/totp/recovery-codes", handler.RegenerateRecoveryCodes(st, loginGuard))                               // 重新生成恢复码
+
+		// 审计日志（仅 admin）
+		api.GET("/audit", middleware.RequireRole(config.RoleAdmin), handler.ListAuditLogs(db))
+
+		// 管理接口（仅 admin）：应用、用户、事件白名单
+		admin := api.Group("/admin", middleware.RequireRole(config.RoleAdmin))
+		admin.GET("/apps", handler.AdminListApps(st))
+		admin.POST("/apps", handler.AdminCreateApp(st))
+		admin.PUT("/apps/:appId", handler.AdminUpdateApp(st))
+		admin.DELETE("/apps/:appId", handler.AdminDeleteApp(st))
+		admin.GET("/apps/:appId/secrets", handler.AdminListAppSecrets(st))
+		admin.POST("/apps/:appId/secrets", handler.AdminCreateAppSecret(st))
+		admin.PUT("/apps/:appId/secrets/:secretId", handler.AdminUpdateAppSecret(st))
+		admin.DELETE("/apps/:appId/secrets/:secretId", handler.AdminRevokeAppSecret(st))
+		admin.GET("/users", handler.AdminListUsers(st))
+		admin.POST("/users", handler.AdminCreateUser(st))
+		admin.PUT("/users/:username", handler.AdminUpdateUser(st))
+		admin.DELETE("/users/:username", handler.AdminDeleteUser(st))
+		admin.GET("/users/:username/sessions", handler.AdminListUserSessions(st))
+		admin.DELETE("/users/:username/sessions", handler.AdminRevokeUserSessions(st))
+		admin.DELETE("/users/:username/totp", handler.AdminResetUserTOTP(st))
+		admin.GET("/events", handler.AdminListEvents(st))
+		admin.POST("/events", handler.AdminCreateEvent(st))
+		admin.PUT("/events/:eventName", handler.AdminUpdateEvent(st))
+		admin.DELETE("/events/:eventName", handler.AdminDeleteEvent(st))
+		admin.GET("/pending-events", handler.AdminListPendingEvents(st))
+		admin.POST("/pending-events/:eventName/approve", handler.AdminApprovePendingEvent(st))
+		admin.DELETE("/pending-events/:eventName", handler.AdminDismissPendingEvent(st))
 	}
 
 	// 上报接口组（HMAC 签名验证 + 限速，SDK 调用）
+	// 签名验证前按 IP 限速，验证后按应用限速（令牌桶）
+	limiter := middleware.NewRateLimiter(cfg.RateLimiter)
+	limiter.StartCleaner()
 	report := r.Group("/report")
-	report.Use(middleware.RateLimit(cfg.RateLimit))
-	report.Use(middleware.SignAuth(cfg))
+	report.Use(limiter.ByIP())
+	report.Use(middleware.SignAuth(st))
+	report.Use(limiter.ByApp())
 	{
-		report.POST("/error", handler.ReportError(db))
-		report.POST("/event", handler.ReportEvent(db, cfg))
+		report.POST("/error", handler.ReportError(queue, st))
+		report.POST("/event", handler.ReportEvent(queue, st))
+	}
+
+	// Prometheus 指标：单独监听地址（内网）或主端口 + Token
+	if cfg.Metrics.Enabled {
+		metricsHandler := metrics.Handler(cfg.Metrics.Token)
+		if cfg.Metrics.Addr != "" {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metricsHandler)
+			go func() {
+				logger.Info("[Tracely] Metrics server started", "addr", cfg.Metrics.Addr)
+				if err := http.ListenAndServe(cfg.Metrics.Addr, mux); err != nil {
+					logger.Error("[Tracely] Metrics server stopped", "error", err)
+				}
+			}()
+		} else {
+			r.GET("/metrics", gin.WrapH(metricsHandler))
+		}
 	}
 
 	// 7. 配置静态文件服务（内嵌前端资源）
@@ -188,13 +332,67 @@ func runServer() {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
 	})
 
+	// 配置热加载：收到 SIGHUP 或 config.yaml 变化时重新读取，校验通过后发布新配置，
+	// 处理函数每次请求读取 config.Current()，以下组件在回调中更新
+	config.OnReload(func(_, cur *config.Config) {
+		limiter.SetConfig(cur.RateLimiter)
+		loginGuard.SetConfig(cur.LoginGuard)
+		middleware.SetDashboardOrigins(cur.DashboardOrigins)
+		st.SetSpikeProtection(cur.SpikeProtection)
+		st.SetEventDiscovery(cur.EventDiscovery)
+		if err := st.SetScrubRules(cur.Scrub); err != nil {
+			logger.Error("[Tracely] Failed to apply scrub rules", "error", err)
+		}
+	})
+	config.Watch()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			config.ReloadAndLog("SIGHUP")
+		}
+	}()
+
 	// 10. 启动服务
-	addr := fmt.Sprintf(":%s", cfg.Port)
-	logger.Info("[Tracely] Server started on port", "port", cfg.Port)
-	if err := r.Run(addr); err != nil {
-		logger.Error("[Tracely] Failed to start server", "error", err)
-		os.Exit(1)
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%s", cfg.Port),
+		Handler: r,
+	}
+	go func() {
+		logger.Info("[Tracely] Server started on port", "port", cfg.Port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("[Tracely] Failed to start server", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	// 11. 收到退出信号后停止接收请求，再写完队列中已接收的上报
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	logger.Info("[Tracely] Shutting down...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Error("[Tracely] Failed to shut down server", "error", err)
+	}
+	if err := queue.Close(ctx); err != nil {
+		logger.Error("[Tracely] Failed to flush ingest queue", "error", err, "pending", queue.Len())
+	}
+	if err := st.FlushSecretUsage(); err != nil {
+		logger.Error("[Tracely] Failed to flush app secret usage", "error", err)
+	}
+	if err := st.FlushAppUsage(); err != nil {
+		logger.Error("[Tracely] Failed to flush app usage", "error", err)
+	}
+	if err := st.FlushValidationFailures(); err != nil {
+		logger.Error("[Tracely] Failed to flush event validation failures", "error", err)
+	}
+	if err := st.FlushPendingEvents(); err != nil {
+		logger.Error("[Tracely] Failed to flush pending events", "error", err)
 	}
+	logger.Info("[Tracely] Server stopped")
 }
 
 // generateSecureRandom 生成安全随机字符串（用于生成 Secret）
@@ -0,0 +1,183 @@
+// mock-oidc 本地调试用的 OIDC IdP（授权码 + PKCE），自动以指定用户登录
+//
+// 用法：
+//
+//	go run ./scripts/mock-oidc -user alice -groups tracely-admins
+//
+// Tracely 配置：
+//
+//	oidc:
+//	  enabled: true
+//	  issuer: "http://localhost:9000"
+//	  clientId: "tracely"
+//	  redirectUrl: "http://localhost:3001/auth/oidc/callback"
+//	  roleMappings:
+//	    - group: "tracely-admins"
+//	      role: "admin"
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const keyID = "mock-key"
+
+// grant 已签发的授权码
+type grant struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	username      string
+}
+
+func main() {
+	addr := flag.String("addr", ":9000", "监听地址")
+	issuer := flag.String("issuer", "http://localhost:9000", "issuer（需与 Tracely 配置一致）")
+	clientID := flag.String("client-id", "tracely", "允许的 client_id")
+	user := flag.String("user", "alice", "登录的用户名（可用 login_hint 参数覆盖）")
+	groups := flag.String("groups", "tracely-admins", "用户所属分组，逗号分隔")
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	grants := make(map[string]grant)
+
+	writeJSON := func(w http.ResponseWriter, status int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+
+	http.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"issuer":                                *issuer,
+			"authorization_endpoint":                *issuer + "/authorize",
+			"token_endpoint":                        *issuer + "/token",
+			"jwks_uri":                              *issuer + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"code_challenge_methods_supported":      []string{"S256"},
+		})
+	})
+
+	http.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": keyID,
+				"kty": "RSA",
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+
+	// 授权：不显示登录页，直接以 -user 指定的用户登录
+	http.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("client_id") != *clientID || q.Get("code_challenge_method") != "S256" {
+			http.Error(w, "invalid client_id or missing PKCE", http.StatusBadRequest)
+			return
+		}
+		redirectURI, err := url.Parse(q.Get("redirect_uri"))
+		if err != nil || redirectURI.Scheme == "" {
+			http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+			return
+		}
+
+		username := *user
+		if hint := q.Get("login_hint"); hint != "" {
+			username = hint
+		}
+		code := rand.Text()
+		mu.Lock()
+		grants[code] = grant{
+			clientID:      q.Get("client_id"),
+			redirectURI:   q.Get("redirect_uri"),
+			nonce:         q.Get("nonce"),
+			codeChallenge: q.Get("code_challenge"),
+			username:      username,
+		}
+		mu.Unlock()
+
+		params := redirectURI.Query()
+		params.Set("code", code)
+		params.Set("state", q.Get("state"))
+		redirectURI.RawQuery = params.Encode()
+		log.Printf("authorize %s -> %s", username, redirectURI.Host)
+		http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+	})
+
+	http.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != "authorization_code" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+			return
+		}
+
+		mu.Lock()
+		g, ok := grants[r.Form.Get("code")]
+		delete(grants, r.Form.Get("code"))
+		mu.Unlock()
+
+		clientID := r.Form.Get("client_id")
+		if id, _, ok := r.BasicAuth(); ok {
+			clientID, _ = url.QueryUnescape(id)
+		}
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if !ok || g.clientID != clientID || g.redirectURI != r.Form.Get("redirect_uri") ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != g.codeChallenge {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		now := time.Now()
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":                *issuer,
+			"sub":                "mock|" + g.username,
+			"aud":                g.clientID,
+			"exp":                now.Add(5 * time.Minute).Unix(),
+			"iat":                now.Unix(),
+			"nonce":              g.nonce,
+			"preferred_username": g.username,
+			"email":              g.username + "@example.com",
+			"groups":             strings.Split(*groups, ","),
+		})
+		token.Header["kid"] = keyID
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"access_token": rand.Text(),
+			"token_type":   "Bearer",
+			"expires_in":   300,
+			"id_token":     idToken,
+		})
+	})
+
+	fmt.Printf("Mock OIDC provider listening on %s (issuer %s, user %s, groups %s)\n", *addr, *issuer, *user, *groups)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
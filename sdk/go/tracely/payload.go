@@ -7,6 +7,7 @@ type ErrorPayload struct {
 	Stack   string `json:"stack"`
 	URL     string `json:"url"`
 	AppID   string `json:"appId"`
+	UserID  string `json:"userId,omitempty"` // 可选，关联用户时间线
 }
 
 // EventPayload 事件上报数据结构
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -13,7 +14,6 @@ import (
 type reportTask struct {
 	url        string
 	body       interface{}
-	headers    map[string]string
 	retryCount int
 }
 
@@ -26,52 +26,74 @@ func (c *Client) startQueueWorker() {
 	}()
 }
 
+// 重试等待时间
+const (
+	defaultRetryDelay = time.Second      // 默认等待时间
+	maxRetryDelay     = 30 * time.Second // Retry-After 超过该值（如应用配额已用完）时放弃重试
+)
+
 // sendWithRetry 发送请求，失败自动重试
+// 每次重试重新签名（服务端已记录上次的 Nonce），服务端繁忙（503）时按 Retry-After 等待
 func (c *Client) sendWithRetry(task *reportTask) {
 	for i := 0; i < 3; i++ {
-		err := c.send(task.url, task.body, task.headers)
+		retryAfter, err := c.send(task.url, task.body)
 		if err == nil {
 			return // 成功则返回
 		}
 		slog.Error("failed to send request", "err", err)
+		if retryAfter > maxRetryDelay {
+			return
+		}
 
-		// 失败则等待 1 秒后重试
-		time.Sleep(time.Second)
+		// 失败则等待后重试
+		time.Sleep(retryAfter)
 	}
 	// 重试 3 次后放弃，不阻塞业务
 }
 
-// send 发送 HTTP POST 请求
-func (c *Client) send(url string, body interface{}, headers map[string]string) error {
+// send 发送 HTTP POST 请求，失败时返回建议的重试等待时间
+func (c *Client) send(url string, body interface{}) (time.Duration, error) {
 	// 序列化 body 为 JSON
 	jsonData, err := json.Marshal(body)
 	if err != nil {
-		return fmt.Errorf("failed to marshal body: %w", err)
+		return defaultRetryDelay, fmt.Errorf("failed to marshal body: %w", err)
 	}
 
 	// 创建请求
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return defaultRetryDelay, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// 设置请求头
 	req.Header.Set("Content-Type", "application/json")
-	for k, v := range headers {
+	for k, v := range buildHeaders(c.config.AppID, c.config.AppSecret) {
 		req.Header.Set(k, v)
 	}
 
 	// 发送请求
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+		return defaultRetryDelay, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// 检查响应状态
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return retryDelay(resp), fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
-	return nil
+	return 0, nil
+}
+
+// retryDelay 读取 503 / 429 响应的 Retry-After（秒），没有时使用默认等待时间
+func retryDelay(resp *http.Response) time.Duration {
+	if resp.StatusCode != http.StatusServiceUnavailable && resp.StatusCode != http.StatusTooManyRequests {
+		return defaultRetryDelay
+	}
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return defaultRetryDelay
+	}
+	return time.Duration(seconds) * time.Second
 }
@@ -1,4 +1,5 @@
 import { signedFetch } from './request'
+import { getUserId } from './tracker'
 
 /**
  * 错误上报数据结构
@@ -54,8 +55,8 @@ function reportError(config: TracelyConfig, errorData: ErrorData): void {
     return
   }
 
-  // 服务端要求 appId 在请求体中（binding:"required"）
-  const body = { ...errorData, appId: config.appId }
+  // 服务端要求 appId 在请求体中（binding:"required"），userId 用于关联用户时间线
+  const body = { ...errorData, appId: config.appId, userId: getUserId() }
   signedFetch(config.host, '/report/error', body, config.appId, config.appSecret)
 }
 /**
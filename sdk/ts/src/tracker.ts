@@ -11,7 +11,7 @@ const EVENT_ACTIVE = '_active'
  */
 const USER_ID_KEY = '_tracely_uid'
 
-function getUserId(): string {
+export function getUserId(): string {
   let userId = localStorage.getItem(USER_ID_KEY)
   if (!userId) {
     if (typeof crypto !== 'undefined' && crypto.randomUUID) {